@@ -2,7 +2,10 @@ module github.com/acai-travel/go-openai-router/v2
 
 go 1.23.4
 
-require github.com/openai/openai-go v0.1.0-alpha.56
+require (
+	github.com/openai/openai-go v0.1.0-alpha.56
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/Azure/azure-sdk-for-go/sdk/azcore v1.14.0 // indirect
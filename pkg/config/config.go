@@ -0,0 +1,330 @@
+// Package config loads the configuration of a router from a YAML or JSON file, validates it and applies the
+// changes of the file to a live router.
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/acai-travel/go-openai-router/v2/pkg/router"
+	"github.com/acai-travel/go-openai-router/v2/pkg/server"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the format of a configuration file.
+type Format string
+
+const (
+	YAMLFormat Format = "yaml"
+	JSONFormat Format = "json"
+)
+
+// Config is the configuration of a router. The zero values of its fields, and of the fields of its servers, are
+// replaced by the defaults of the router.
+type Config struct {
+	Strategy    router.RouterStrategyType `json:"strategy,omitempty" yaml:"strategy,omitempty"` // Strategy is the type of strategy of the router, round-robin by default.
+	MaxAttempts int                       `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty"`
+	Fallbacks   map[string][]string       `json:"fallbacks,omitempty" yaml:"fallbacks,omitempty"`
+	// HealthCheckInterval enables the health checks of the servers, see router.WithHealthChecks.
+	HealthCheckInterval Duration `json:"health_check_interval,omitempty" yaml:"health_check_interval,omitempty"`
+	Servers             []Server `json:"servers" yaml:"servers"`
+}
+
+// Server is the configuration of a server, see server.ServerConfig. The api_key can reference environment
+// variables, for example "${AZURE_OPENAI_API_KEY}", so that the keys are not stored in the file.
+type Server struct {
+	Name            string                  `json:"name,omitempty" yaml:"name,omitempty"`
+	Type            server.ServerConfigType `json:"type" yaml:"type"`
+	Endpoint        string                  `json:"endpoint" yaml:"endpoint"`
+	AzureAPIVersion string                  `json:"azure_api_version,omitempty" yaml:"azure_api_version,omitempty"`
+	ApiKey          string                  `json:"api_key,omitempty" yaml:"api_key,omitempty"`
+	Models          []string                `json:"models,omitempty" yaml:"models,omitempty"`           // Models are the AvailableModels of the server.
+	Deployments     map[string]string       `json:"deployments,omitempty" yaml:"deployments,omitempty"` // Deployments maps the logical model names to the deployments of the server.
+	Headers         map[string]string       `json:"headers,omitempty" yaml:"headers,omitempty"`
+	QueryParams     map[string]string       `json:"query_params,omitempty" yaml:"query_params,omitempty"`
+	Labels          map[string]string       `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Weight          int                     `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Limits          map[string]Limits       `json:"limits,omitempty" yaml:"limits,omitempty"` // Limits are the quotas of the models of the server.
+	CircuitBreaker  CircuitBreaker          `json:"circuit_breaker,omitempty" yaml:"circuit_breaker,omitempty"`
+	HealthCheck     HealthCheck             `json:"health_check,omitempty" yaml:"health_check,omitempty"`
+	Discovery       Discovery               `json:"discovery,omitempty" yaml:"discovery,omitempty"`
+	StatsHalfLife   Duration                `json:"stats_half_life,omitempty" yaml:"stats_half_life,omitempty"`
+}
+
+// Limits are the quotas of a model, see server.ModelLimits.
+type Limits struct {
+	TokensPerMinute   int64 `json:"tokens_per_minute,omitempty" yaml:"tokens_per_minute,omitempty"`
+	RequestsPerMinute int64 `json:"requests_per_minute,omitempty" yaml:"requests_per_minute,omitempty"`
+}
+
+// CircuitBreaker is the configuration of the circuit breaker of a server, see server.CircuitBreakerConfig.
+type CircuitBreaker struct {
+	Disabled            bool     `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	ConsecutiveFailures int      `json:"consecutive_failures,omitempty" yaml:"consecutive_failures,omitempty"`
+	ErrorRateThreshold  float64  `json:"error_rate_threshold,omitempty" yaml:"error_rate_threshold,omitempty"`
+	WindowSize          int      `json:"window_size,omitempty" yaml:"window_size,omitempty"`
+	MinRequests         int      `json:"min_requests,omitempty" yaml:"min_requests,omitempty"`
+	CoolDown            Duration `json:"cool_down,omitempty" yaml:"cool_down,omitempty"`
+}
+
+// HealthCheck is the configuration of the health checks of a server, see server.HealthCheckConfig.
+type HealthCheck struct {
+	Probe              server.HealthProbe `json:"probe,omitempty" yaml:"probe,omitempty"`
+	Models             []string           `json:"models,omitempty" yaml:"models,omitempty"`
+	UnhealthyThreshold int                `json:"unhealthy_threshold,omitempty" yaml:"unhealthy_threshold,omitempty"`
+	HealthyThreshold   int                `json:"healthy_threshold,omitempty" yaml:"healthy_threshold,omitempty"`
+	Timeout            Duration           `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// Discovery is the configuration of the discovery of the models of a server, see server.ModelDiscovery.
+type Discovery struct {
+	Enabled  bool     `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+}
+
+// Duration is a time.Duration written like "30s" or "1m30s" in the files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// Load reads the configuration file at path, a YAML file or a JSON file depending on its extension, and validates it.
+func Load(path string) (*Config, error) {
+	format, err := formatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Parse decodes a configuration in the given format and validates it. Unknown fields are rejected so that typos
+// are not silently ignored.
+func Parse(data []byte, format Format) (*Config, error) {
+	config := &Config{}
+	switch format {
+	case YAMLFormat:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil {
+			return nil, err
+		}
+	case JSONFormat:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(config); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("format %s is not supported", format)
+	}
+	for i := range config.Servers {
+		config.Servers[i].ApiKey = os.ExpandEnv(config.Servers[i].ApiKey)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// formatOf returns the format of the file at path from its extension.
+func formatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAMLFormat, nil
+	case ".json":
+		return JSONFormat, nil
+	}
+	return "", fmt.Errorf("%s: unknown configuration format, use a .yaml, .yml or .json file", path)
+}
+
+// Validate checks the configuration and returns every problem it found, each one prefixed with the path of the
+// invalid field, for example "servers[1] (eastus): azure_api_version is required for azure-openai servers".
+func (c *Config) Validate() error {
+	errs := []error{}
+	if c.Strategy != "" {
+		if _, err := router.NewStrategy(c.Strategy); err != nil {
+			errs = append(errs, fmt.Errorf("strategy: %w", err))
+		}
+	}
+	if c.MaxAttempts < 0 {
+		errs = append(errs, fmt.Errorf("max_attempts: must not be negative"))
+	}
+	if c.HealthCheckInterval < 0 {
+		errs = append(errs, fmt.Errorf("health_check_interval: must not be negative"))
+	}
+	if len(c.Servers) == 0 {
+		errs = append(errs, fmt.Errorf("servers: at least one server is required"))
+	}
+	names := map[string]int{}
+	for i, s := range c.Servers {
+		prefix := fmt.Sprintf("servers[%d]", i)
+		if s.name() != "" {
+			prefix += fmt.Sprintf(" (%s)", s.name())
+		}
+		if first, ok := names[s.name()]; ok && s.name() != "" {
+			errs = append(errs, fmt.Errorf("%s: name %s is already used by servers[%d]", prefix, s.name(), first))
+		} else {
+			names[s.name()] = i
+		}
+		for _, err := range s.validate() {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validate returns the problems of the configuration of the server.
+func (s Server) validate() []error {
+	errs := []error{}
+	switch s.Type {
+	case server.AzureOpenAiServerType:
+		if s.AzureAPIVersion == "" {
+			errs = append(errs, fmt.Errorf("azure_api_version is required for %s servers", s.Type))
+		}
+	case server.OpenAiServerType, server.OpenAiCompatibleServerType:
+	case "":
+		errs = append(errs, fmt.Errorf("type is required"))
+	default:
+		errs = append(errs, fmt.Errorf("type %s is not supported, use %s, %s or %s", s.Type,
+			server.AzureOpenAiServerType, server.OpenAiServerType, server.OpenAiCompatibleServerType))
+	}
+	if s.Endpoint == "" {
+		errs = append(errs, fmt.Errorf("endpoint is required"))
+	} else if endpoint, err := url.Parse(s.Endpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		errs = append(errs, fmt.Errorf("endpoint %s is not an http or https URL", s.Endpoint))
+	}
+	if s.ApiKey == "" && s.Type != server.OpenAiCompatibleServerType {
+		errs = append(errs, fmt.Errorf("api_key is required for %s servers", cmp.Or(s.Type, "these")))
+	}
+	if len(s.Models) == 0 && len(s.Deployments) == 0 && !s.Discovery.Enabled {
+		errs = append(errs, fmt.Errorf("models, deployments or discovery is required"))
+	}
+	if s.Weight < 0 {
+		errs = append(errs, fmt.Errorf("weight must not be negative"))
+	}
+	for model, limits := range s.Limits {
+		if limits.TokensPerMinute < 0 || limits.RequestsPerMinute < 0 {
+			errs = append(errs, fmt.Errorf("limits of %s must not be negative", model))
+		}
+	}
+	if s.CircuitBreaker.ErrorRateThreshold < 0 || s.CircuitBreaker.ErrorRateThreshold > 1 {
+		errs = append(errs, fmt.Errorf("circuit_breaker.error_rate_threshold must be between 0 and 1"))
+	}
+	switch s.HealthCheck.Probe {
+	case "", server.ModelsProbe:
+	case server.CompletionProbe:
+		if len(s.HealthCheck.Models) == 0 {
+			errs = append(errs, fmt.Errorf("health_check.models is required for the %s probe", server.CompletionProbe))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("health_check.probe %s is not supported, use %s or %s", s.HealthCheck.Probe,
+			server.ModelsProbe, server.CompletionProbe))
+	}
+	if s.StatsHalfLife < 0 {
+		errs = append(errs, fmt.Errorf("stats_half_life must not be negative"))
+	}
+	return errs
+}
+
+// name returns the name the router gives to the server.
+func (s Server) name() string {
+	return cmp.Or(s.Name, s.Endpoint)
+}
+
+// ServerConfig returns the server.ServerConfig of the server.
+func (s Server) ServerConfig() server.ServerConfig {
+	var limits map[string]server.ModelLimits
+	if len(s.Limits) > 0 {
+		limits = map[string]server.ModelLimits{}
+	}
+	for model, l := range s.Limits {
+		limits[model] = server.ModelLimits{TokensPerMinute: l.TokensPerMinute, RequestsPerMinute: l.RequestsPerMinute}
+	}
+	return server.ServerConfig{
+		Name:            s.Name,
+		Endpoint:        s.Endpoint,
+		AzureAPIVersion: s.AzureAPIVersion,
+		ApiKey:          s.ApiKey,
+		Type:            s.Type,
+		AvailableModels: s.Models,
+		Deployments:     s.Deployments,
+		Headers:         s.Headers,
+		QueryParams:     s.QueryParams,
+		Labels:          s.Labels,
+		Weight:          s.Weight,
+		ModelLimits:     limits,
+		CircuitBreaker: server.CircuitBreakerConfig{
+			Disabled:            s.CircuitBreaker.Disabled,
+			ConsecutiveFailures: s.CircuitBreaker.ConsecutiveFailures,
+			ErrorRateThreshold:  s.CircuitBreaker.ErrorRateThreshold,
+			WindowSize:          s.CircuitBreaker.WindowSize,
+			MinRequests:         s.CircuitBreaker.MinRequests,
+			CoolDown:            time.Duration(s.CircuitBreaker.CoolDown),
+		},
+		HealthCheck: server.HealthCheckConfig{
+			Probe:              s.HealthCheck.Probe,
+			Models:             s.HealthCheck.Models,
+			UnhealthyThreshold: s.HealthCheck.UnhealthyThreshold,
+			HealthyThreshold:   s.HealthCheck.HealthyThreshold,
+			Timeout:            time.Duration(s.HealthCheck.Timeout),
+		},
+		Discovery:     server.ModelDiscovery{Enabled: s.Discovery.Enabled, Interval: time.Duration(s.Discovery.Interval)},
+		StatsHalfLife: time.Duration(s.StatsHalfLife),
+	}
+}
+
+// ServerConfigs returns the server.ServerConfig of every server of the configuration.
+func (c *Config) ServerConfigs() []server.ServerConfig {
+	serverConfigs := make([]server.ServerConfig, 0, len(c.Servers))
+	for _, s := range c.Servers {
+		serverConfigs = append(serverConfigs, s.ServerConfig())
+	}
+	return serverConfigs
+}
+
+// NewRouter validates the configuration and creates a router from it. The opts are applied after the options of
+// the configuration, so they take precedence.
+func NewRouter(config *Config, opts ...router.RouterOption) (*router.Router, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return router.NewRouter(config.ServerConfigs(), cmp.Or(config.Strategy, router.RoundRobinStrategy), append(config.routerOptions(), opts...)...)
+}
+
+// routerOptions returns the options of the router set by the configuration.
+func (c *Config) routerOptions() []router.RouterOption {
+	opts := []router.RouterOption{router.WithMaxAttempts(c.MaxAttempts)}
+	if len(c.Fallbacks) > 0 {
+		opts = append(opts, router.WithFallbacks(c.Fallbacks))
+	}
+	if c.HealthCheckInterval > 0 {
+		opts = append(opts, router.WithHealthChecks(time.Duration(c.HealthCheckInterval)))
+	}
+	return opts
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acai-travel/go-openai-router/v2/pkg/router"
+	"github.com/acai-travel/go-openai-router/v2/pkg/server"
+)
+
+const testYAML = `
+strategy: least-latency
+max_attempts: 2
+fallbacks:
+  gpt-4o: [gpt-4o-mini]
+servers:
+  - name: eastus
+    type: azure-openai
+    endpoint: https://eastus.openai.azure.com
+    azure_api_version: "2024-06-01"
+    api_key: ${TEST_AZURE_OPENAI_API_KEY}
+    deployments:
+      gpt-4o: gpt4o-eastus
+    limits:
+      gpt-4o:
+        tokens_per_minute: 30000
+        requests_per_minute: 180
+    circuit_breaker:
+      consecutive_failures: 3
+      cool_down: 1m
+    health_check:
+      probe: completion
+      models: [gpt-4o]
+      timeout: 5s
+  - type: openai-compatible
+    endpoint: http://localhost:8000/v1
+    weight: 2
+    discovery:
+      enabled: true
+      interval: 30s
+`
+
+const testJSON = `{
+  "strategy": "least-latency",
+  "max_attempts": 2,
+  "fallbacks": {"gpt-4o": ["gpt-4o-mini"]},
+  "servers": [
+    {
+      "name": "eastus",
+      "type": "azure-openai",
+      "endpoint": "https://eastus.openai.azure.com",
+      "azure_api_version": "2024-06-01",
+      "api_key": "${TEST_AZURE_OPENAI_API_KEY}",
+      "deployments": {"gpt-4o": "gpt4o-eastus"},
+      "limits": {"gpt-4o": {"tokens_per_minute": 30000, "requests_per_minute": 180}},
+      "circuit_breaker": {"consecutive_failures": 3, "cool_down": "1m"},
+      "health_check": {"probe": "completion", "models": ["gpt-4o"], "timeout": "5s"}
+    },
+    {
+      "type": "openai-compatible",
+      "endpoint": "http://localhost:8000/v1",
+      "weight": 2,
+      "discovery": {"enabled": true, "interval": "30s"}
+    }
+  ]
+}`
+
+func TestLoad(t *testing.T) {
+	t.Setenv("TEST_AZURE_OPENAI_API_KEY", "azure-openai-key")
+	expected := []server.ServerConfig{
+		{
+			Name:            "eastus",
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        "https://eastus.openai.azure.com",
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			Deployments:     map[string]string{"gpt-4o": "gpt4o-eastus"},
+			ModelLimits:     map[string]server.ModelLimits{"gpt-4o": {TokensPerMinute: 30000, RequestsPerMinute: 180}},
+			CircuitBreaker:  server.CircuitBreakerConfig{ConsecutiveFailures: 3, CoolDown: time.Minute},
+			HealthCheck:     server.HealthCheckConfig{Probe: server.CompletionProbe, Models: []string{"gpt-4o"}, Timeout: 5 * time.Second},
+		},
+		{
+			Type:      server.OpenAiCompatibleServerType,
+			Endpoint:  "http://localhost:8000/v1",
+			Weight:    2,
+			Discovery: server.ModelDiscovery{Enabled: true, Interval: 30 * time.Second},
+		},
+	}
+	for name, data := range map[string]string{"router.yaml": testYAML, "router.json": testJSON} {
+		config, err := Load(writeTestFile(t, name, data))
+		if err != nil {
+			t.Fatalf("%s: error was not expected %v", name, err)
+		}
+		if config.Strategy != router.LeastLatencyStrategy || config.MaxAttempts != 2 || !reflect.DeepEqual(config.Fallbacks, map[string][]string{"gpt-4o": {"gpt-4o-mini"}}) {
+			t.Fatalf("%s: incorrect router settings %+v", name, config)
+		}
+		if !reflect.DeepEqual(config.ServerConfigs(), expected) {
+			t.Fatalf("%s: incorrect server configs %+v", name, config.ServerConfigs())
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		data     string
+		expected []string
+	}{
+		{
+			name:     "unknown format",
+			file:     "router.toml",
+			expected: []string{"unknown configuration format"},
+		},
+		{
+			name:     "unknown field",
+			file:     "router.yaml",
+			data:     "servers:\n  - type: openai\n    endpoint: https://api.openai.com/v1\n    apikey: openai-key\n",
+			expected: []string{"field apikey not found"},
+		},
+		{
+			name:     "invalid duration",
+			file:     "router.json",
+			data:     `{"servers": [{"type": "openai", "stats_half_life": "a minute"}]}`,
+			expected: []string{"invalid duration"},
+		},
+		{
+			name:     "no servers",
+			file:     "router.yaml",
+			data:     "strategy: fastest\n",
+			expected: []string{"strategy: unknown strategy fastest", "servers: at least one server is required"},
+		},
+		{
+			name: "invalid servers",
+			file: "router.yaml",
+			data: `
+servers:
+  - name: eastus
+    type: azure-openai
+    endpoint: https://eastus.openai.azure.com
+    api_key: azure-openai-key
+    models: [gpt-4o]
+  - name: eastus
+    type: openai
+    endpoint: api.openai.com
+    models: [gpt-4o]
+  - type: anthropic
+    endpoint: https://api.anthropic.com
+    api_key: anthropic-key
+    health_check:
+      probe: ping
+  - type: openai
+    endpoint: https://api.openai.com/v1
+    api_key: openai-key
+    models: [gpt-4o, text-embedding-3-small]
+    health_check:
+      probe: completion
+`,
+			expected: []string{
+				"servers[0] (eastus): azure_api_version is required for azure-openai servers",
+				"servers[1] (eastus): name eastus is already used by servers[0]",
+				"servers[1] (eastus): endpoint api.openai.com is not an http or https URL",
+				"servers[1] (eastus): api_key is required for openai servers",
+				"servers[2] (https://api.anthropic.com): type anthropic is not supported",
+				"servers[2] (https://api.anthropic.com): models, deployments or discovery is required",
+				"servers[2] (https://api.anthropic.com): health_check.probe ping is not supported",
+				"servers[3] (https://api.openai.com/v1): health_check.models is required for the completion probe",
+			},
+		},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), test.file)
+		if test.data != "" {
+			path = writeTestFile(t, test.file, test.data)
+		}
+		_, err := Load(path)
+		if err == nil {
+			t.Fatalf("%s: error was expected", test.name)
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Fatalf("%s: incorrect error %q, expected %q", test.name, err, expected)
+			}
+		}
+	}
+}
+
+func TestNewRouter(t *testing.T) {
+	config, err := Parse([]byte(`
+strategy: weighted-round-robin
+servers:
+  - name: openai
+    type: openai
+    endpoint: https://api.openai.com/v1
+    api_key: openai-key
+    models: [gpt-4o]
+`), YAMLFormat)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	r, err := NewRouter(config)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer r.Close()
+	if !reflect.DeepEqual(r.Servers(), []string{"openai"}) {
+		t.Fatalf("Incorrect servers %v", r.Servers())
+	}
+	if _, err := NewRouter(&Config{}); err == nil {
+		t.Fatal("Error was expected for an invalid configuration")
+	}
+}
+
+// writeTestFile writes data to a file named name in a temporary directory and returns its path.
+func writeTestFile(t *testing.T, name string, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	return path
+}
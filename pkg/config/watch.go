@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/acai-travel/go-openai-router/v2/pkg/router"
+	"github.com/acai-travel/go-openai-router/v2/pkg/server"
+)
+
+// DefaultWatchInterval is how often Watch reads the configuration file when no interval is given.
+const DefaultWatchInterval = 10 * time.Second
+
+// ErrSettingsIgnored is returned by Apply when the configuration changes router settings that cannot change on
+// a live router. The rest of the configuration is applied.
+var ErrSettingsIgnored = errors.New("settings only apply to new routers")
+
+// Apply updates r, created from the configuration from, to the configuration to. The servers are matched by
+// name: the removed servers are removed, the new ones are added and the changed ones are replaced, losing their
+// stats, all in a single step with router.UpdateServers. The unchanged servers are kept along with their stats.
+// The changed max_attempts and fallbacks are set on r once the servers are updated. The strategy and
+// health_check_interval cannot change on a live router: when they change, Apply returns an error wrapping
+// ErrSettingsIgnored that names them, after applying the rest of the configuration.
+func Apply(r *router.Router, from, to *Config) error {
+	if err := to.Validate(); err != nil {
+		return err
+	}
+	previous := map[string]Server{}
+	for _, s := range from.Servers {
+		previous[s.name()] = s
+	}
+	next := map[string]bool{}
+	remove := []string{}
+	add := []server.ServerConfig{}
+	for _, s := range to.Servers {
+		next[s.name()] = true
+		current, ok := previous[s.name()]
+		if ok && reflect.DeepEqual(current, s) {
+			continue
+		}
+		if ok {
+			remove = append(remove, s.name())
+		}
+		add = append(add, s.ServerConfig())
+	}
+	for _, s := range from.Servers {
+		if !next[s.name()] {
+			remove = append(remove, s.name())
+		}
+	}
+	if len(remove) > 0 || len(add) > 0 {
+		if err := r.UpdateServers(remove, add); err != nil {
+			return err
+		}
+		slog.Info("Router Servers Updated", "removed", len(remove), "added", len(add))
+	}
+	if from.MaxAttempts != to.MaxAttempts {
+		r.SetMaxAttempts(to.MaxAttempts)
+	}
+	if !reflect.DeepEqual(from.Fallbacks, to.Fallbacks) {
+		r.SetFallbacks(to.Fallbacks)
+	}
+	ignored := []string{}
+	if cmp.Or(from.Strategy, router.RoundRobinStrategy) != cmp.Or(to.Strategy, router.RoundRobinStrategy) {
+		ignored = append(ignored, "strategy")
+	}
+	if from.HealthCheckInterval != to.HealthCheckInterval {
+		ignored = append(ignored, "health_check_interval")
+	}
+	if len(ignored) > 0 {
+		return fmt.Errorf("%w: %s", ErrSettingsIgnored, strings.Join(ignored, ", "))
+	}
+	return nil
+}
+
+// Watch reads the configuration file at path every interval and applies its changes to r with Apply, until ctx
+// is done. config is the configuration r was created with. onReload is called after every change of the
+// configuration with the applied configuration, or with the error of an invalid file, in which case r keeps its
+// previous servers. When the configuration changes settings that Apply ignores, onReload is called with both the
+// applied configuration and the error wrapping ErrSettingsIgnored. A file that could not be applied to r is
+// applied again on the next tick, until it succeeds or the file changes. An empty file, such as a file being
+// written, is ignored: replace the file with a rename so that it is never read half written. onReload may be
+// nil. Watch blocks, run it in its own goroutine.
+func Watch(ctx context.Context, path string, r *router.Router, config *Config, interval time.Duration, onReload func(*Config, error)) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	if onReload == nil {
+		onReload = func(config *Config, err error) {
+			if err != nil {
+				slog.Error("Configuration Reload Failed", "path", path, "error", err)
+			}
+		}
+	}
+	format, err := formatOf(path)
+	if err != nil {
+		onReload(nil, err)
+		return
+	}
+	var data []byte
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		next, err := os.ReadFile(path)
+		if err != nil {
+			onReload(nil, err)
+			continue
+		}
+		// An empty file is being written, it is read again on the next tick.
+		if len(next) == 0 || bytes.Equal(next, data) {
+			continue
+		}
+		loaded, err := Parse(next, format)
+		if err != nil {
+			// The same file fails the same way, it is only reported again once it changes.
+			data = next
+			onReload(nil, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		if !reflect.DeepEqual(loaded, config) {
+			err := Apply(r, config, loaded)
+			if err != nil && !errors.Is(err, ErrSettingsIgnored) {
+				// The file is applied again on the next tick, the servers may be created by then.
+				onReload(nil, err)
+				continue
+			}
+			config = loaded
+			onReload(config, err)
+		}
+		data = next
+	}
+}
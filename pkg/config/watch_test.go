@@ -0,0 +1,213 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acai-travel/go-openai-router/v2/pkg/router"
+	"github.com/openai/openai-go"
+)
+
+const testServers = `
+servers:
+  - name: eastus
+    type: openai
+    endpoint: https://eastus.example.com/v1
+    api_key: openai-key
+    models: [gpt-4o]
+  - name: westeurope
+    type: openai
+    endpoint: https://westeurope.example.com/v1
+    api_key: openai-key
+    models: [gpt-4o]
+`
+
+func TestApply(t *testing.T) {
+	from, err := Parse([]byte(testServers), YAMLFormat)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	r, err := NewRouter(from)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer r.Close()
+	to, err := Parse([]byte(`
+servers:
+  - name: westeurope
+    type: openai
+    endpoint: https://westeurope.example.com/v1
+    api_key: openai-key
+    models: [gpt-4o, gpt-4o-mini]
+  - name: eastus
+    type: openai
+    endpoint: https://eastus.example.com/v1
+    api_key: openai-key
+    models: [gpt-4o]
+  - name: swedencentral
+    type: openai
+    endpoint: https://swedencentral.example.com/v1
+    api_key: openai-key
+    models: [gpt-4o]
+`), YAMLFormat)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if err := Apply(r, from, to); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	// The unchanged server is kept in place, the changed one is replaced.
+	if !reflect.DeepEqual(r.Servers(), []string{"eastus", "westeurope", "swedencentral"}) {
+		t.Fatalf("Incorrect servers %v", r.Servers())
+	}
+	if err := Apply(r, to, &Config{}); err == nil {
+		t.Fatal("Error was expected for an invalid configuration")
+	}
+	if len(r.Servers()) != 3 {
+		t.Fatalf("An invalid configuration should not change the servers, got %v", r.Servers())
+	}
+
+}
+
+func TestApplySettings(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":{"message":"test error","type":"test"}}`))
+	}))
+	defer ts.Close()
+	from, err := Parse([]byte(strings.ReplaceAll(`
+servers:
+  - name: eastus
+    type: openai-compatible
+    endpoint: {{endpoint}}
+    models: [gpt-4o]
+  - name: westeurope
+    type: openai-compatible
+    endpoint: {{endpoint}}
+    models: [gpt-4o, gpt-4o-mini]
+`, "{{endpoint}}", ts.URL)), YAMLFormat)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	r, err := NewRouter(from)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer r.Close()
+	to := *from
+	to.MaxAttempts = 1
+	to.Fallbacks = map[string][]string{"gpt-4o": {"gpt-4o-mini"}}
+	to.Strategy = router.LeastLatencyStrategy
+	to.HealthCheckInterval = Duration(time.Minute)
+	err = Apply(r, from, &to)
+	if !errors.Is(err, ErrSettingsIgnored) || !strings.HasSuffix(err.Error(), ": strategy, health_check_interval") {
+		t.Fatalf("The ignored settings should be reported, got %v", err)
+	}
+	info := router.RouteInfo{}
+	ctx := router.ContextWithRouteInfo(context.TODO(), &info)
+	if _, err := r.GetChatCompletions(ctx, openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT4o)}); err == nil {
+		t.Fatal("Error was expected")
+	}
+	if len(info.Attempts) != 2 || info.Attempts[0].Model != "gpt-4o" || info.Attempts[1].Model != "gpt-4o-mini" {
+		t.Fatalf("The max attempts and fallbacks should be applied, got %+v", info.Attempts)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := writeTestFile(t, "router.yaml", testServers)
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	r, err := NewRouter(config)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan error, 10)
+	go Watch(ctx, path, r, config, 5*time.Millisecond, func(config *Config, err error) {
+		reloads <- err
+	})
+
+	replaceTestFile(t, path, "servers: []\n")
+	if err := waitForReload(t, reloads); err == nil {
+		t.Fatal("The error of the invalid file should be reported")
+	}
+	if !reflect.DeepEqual(r.Servers(), []string{"eastus", "westeurope"}) {
+		t.Fatalf("An invalid file should not change the servers, got %v", r.Servers())
+	}
+
+	replaceTestFile(t, path, `
+servers:
+  - name: eastus
+    type: openai
+    endpoint: https://eastus.example.com/v1
+    api_key: openai-key
+    models: [gpt-4o]
+`)
+	if err := waitForReload(t, reloads); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if !reflect.DeepEqual(r.Servers(), []string{"eastus"}) {
+		t.Fatalf("Incorrect servers %v", r.Servers())
+	}
+
+	// eastus cannot be removed by the next file once it is removed from the router, until it is added back.
+	if err := r.RemoveServer("eastus"); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	replaceTestFile(t, path, `
+servers:
+  - name: westeurope
+    type: openai
+    endpoint: https://westeurope.example.com/v1
+    api_key: openai-key
+    models: [gpt-4o]
+`)
+	if err := waitForReload(t, reloads); err == nil {
+		t.Fatal("The error of the update of the servers should be reported")
+	}
+	if err := r.AddServer(config.Servers[0].ServerConfig()); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	for waitForReload(t, reloads) != nil {
+	}
+	if !reflect.DeepEqual(r.Servers(), []string{"westeurope"}) {
+		t.Fatalf("A file that failed to apply should be applied again, got %v", r.Servers())
+	}
+}
+
+// waitForReload waits up to a second for the next reload of Watch and returns its error.
+func waitForReload(t *testing.T, reloads chan error) error {
+	t.Helper()
+	select {
+	case err := <-reloads:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("The file was not reloaded in time")
+		return nil
+	}
+}
+
+// replaceTestFile replaces the file at path with data in a single step, like an editor or a deployment would,
+// so that Watch never reads it half written.
+func replaceTestFile(t *testing.T, path string, data string) {
+	t.Helper()
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, []byte(data), 0o600); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+}
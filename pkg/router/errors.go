@@ -0,0 +1,97 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"github.com/acai-travel/go-openai-router/v2/pkg/server"
+	"github.com/openai/openai-go"
+)
+
+// ErrNoServerAvailable is returned when no server can serve the requested model.
+var ErrNoServerAvailable = errors.New("no server available")
+
+// ErrServerNotFound is returned when a router has no server with the requested name.
+var ErrServerNotFound = errors.New("server not found")
+
+// Attempt records the outcome of dispatching a request to a single server.
+type Attempt struct {
+	Model  string // Model is the logical model the request was sent for.
+	Server string // Server is the endpoint of the server that was tried.
+	Err    error
+}
+
+// FailoverError is returned when a request could not be served by any of the servers it was dispatched to.
+// Attempts holds every server that was tried, in order, along with the error it returned.
+type FailoverError struct {
+	Model    string
+	Attempts []Attempt
+}
+
+func (e *FailoverError) Error() string {
+	parts := make([]string, 0, len(e.Attempts))
+	for _, attempt := range e.Attempts {
+		parts = append(parts, fmt.Sprintf("%s: %v", attempt.Server, attempt.Err))
+	}
+	return fmt.Sprintf("model %s failed after %d attempt(s): %s", e.Model, len(e.Attempts), strings.Join(parts, "; "))
+}
+
+// Unwrap returns the error of every attempt so that errors.Is and errors.As see the underlying causes.
+func (e *FailoverError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Attempts))
+	for _, attempt := range e.Attempts {
+		errs = append(errs, attempt.Err)
+	}
+	return errs
+}
+
+// ModerationError is returned when the moderation configured with WithModeration flags the user messages of a request.
+type ModerationError struct {
+	Model      string              // Model is the moderation model.
+	Categories []string            // Categories are the flagged categories, for example "harassment".
+	Results    []openai.Moderation // Results are the moderation results of each user message.
+}
+
+func (e *ModerationError) Error() string {
+	return fmt.Sprintf("request flagged by moderation: %s", strings.Join(e.Categories, ", "))
+}
+
+// IsRetryable reports whether err is worth retrying on a different server.
+// Throttling (429), request timeouts (408) and server side errors (500, 502, 503, 504) returned by the API
+// are retryable, as are network timeouts, connections that were refused or reset, servers whose circuit is open,
+// servers that would exceed the quota of the model and draining servers.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, server.ErrCircuitOpen) || errors.Is(err, server.ErrQuotaExceeded) || errors.Is(err, server.ErrServerDraining) {
+		return true
+	}
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
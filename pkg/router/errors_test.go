@@ -0,0 +1,59 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/openai/openai-go"
+)
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"nil", nil, false},
+		{"too many requests", &openai.Error{StatusCode: http.StatusTooManyRequests}, true},
+		{"internal server error", &openai.Error{StatusCode: http.StatusInternalServerError}, true},
+		{"bad gateway", &openai.Error{StatusCode: http.StatusBadGateway}, true},
+		{"service unavailable", &openai.Error{StatusCode: http.StatusServiceUnavailable}, true},
+		{"gateway timeout", &openai.Error{StatusCode: http.StatusGatewayTimeout}, true},
+		{"bad request", &openai.Error{StatusCode: http.StatusBadRequest}, false},
+		{"unauthorized", &openai.Error{StatusCode: http.StatusUnauthorized}, false},
+		{"wrapped api error", fmt.Errorf("wrapped: %w", &openai.Error{StatusCode: http.StatusTooManyRequests}), true},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"connection reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"canceled", context.Canceled, false},
+		{"other", errors.New("boom"), false},
+	}
+	for _, c := range cases {
+		if IsRetryable(c.err) != c.retryable {
+			t.Fatalf("IsRetryable(%s) should be %v", c.name, c.retryable)
+		}
+	}
+}
+
+func TestFailoverError(t *testing.T) {
+	apiErr := &openai.Error{StatusCode: http.StatusTooManyRequests}
+	err := &FailoverError{
+		Model: "gpt-4o",
+		Attempts: []Attempt{
+			{Server: "https://one", Err: apiErr},
+			{Server: "https://two", Err: context.DeadlineExceeded},
+		},
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("FailoverError should unwrap to the error of every attempt")
+	}
+	var target *openai.Error
+	if !errors.As(err, &target) || target != apiErr {
+		t.Fatal("FailoverError should unwrap to the openai.Error of the first attempt")
+	}
+}
@@ -0,0 +1,56 @@
+package router
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/acai-travel/go-openai-router/v2/pkg/server"
+)
+
+// WithHealthChecks checks the health of every server when the router is created and then every interval, with
+// the probe of the HealthCheck of its ServerConfig. Servers and models that failed their checks are marked
+// unhealthy and skipped by every strategy until they pass them again. Values lower than or equal to 0 are ignored.
+// Call Close to stop the checks.
+func WithHealthChecks(interval time.Duration) RouterOption {
+	return func(r *Router) {
+		if interval > 0 {
+			r.healthChecks = interval
+		}
+	}
+}
+
+// CheckHealth checks the health of every server concurrently, see server.RouterServer.CheckHealth.
+func (r *Router) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, s := range r.serverList() {
+		wg.Add(1)
+		go func(s *server.RouterServer) {
+			defer wg.Done()
+			if err := s.CheckHealth(ctx); err != nil {
+				slog.Debug("Health Check Failed", "endpoint", s.Endpoint, "error", err)
+			}
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (r *Router) checkHealthPeriodically(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-r.closed
+		cancel()
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.CheckHealth(ctx)
+		select {
+		case <-r.closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/acai-travel/go-openai-router/v2/pkg/server"
+	"github.com/openai/openai-go"
+)
+
+func TestHealthChecksSkipUnhealthyServers(t *testing.T) {
+	healthy := atomic.Bool{}
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !healthy.Load() {
+			testHandler(http.StatusServiceUnavailable).ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[]}`))
+	}))
+	defer flaky.Close()
+	completions := atomic.Int32{}
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/chat/completions") {
+			completions.Add(1)
+		}
+		testHandler(http.StatusOK).ServeHTTP(w, req)
+	}))
+	defer stable.Close()
+	serverConfigs := []server.ServerConfig{}
+	for _, endpoint := range []string{flaky.URL, stable.URL} {
+		serverConfigs = append(serverConfigs, server.ServerConfig{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        endpoint,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			AvailableModels: []string{"gpt-3.5-turbo"},
+			HealthCheck:     server.HealthCheckConfig{UnhealthyThreshold: 1, HealthyThreshold: 1},
+		})
+	}
+	router, err := NewRouter(serverConfigs, RoundRobinStrategy, WithHealthChecks(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer router.Close()
+	waitFor(t, func() bool { return !router.servers[0].IsHealthy("gpt-3.5-turbo") })
+
+	for range 4 {
+		_, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+			Model:    openai.F(openai.ChatModel("gpt-3.5-turbo")),
+			Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")}),
+		})
+		if err != nil {
+			t.Fatalf("Error was not expected %v", err)
+		}
+	}
+	if completions.Load() != 4 {
+		t.Fatalf("Incorrect number of requests to the healthy server %d", completions.Load())
+	}
+
+	healthy.Store(true)
+	waitFor(t, func() bool { return router.servers[0].IsHealthy("gpt-3.5-turbo") })
+}
+
+// waitFor waits up to a second for condition to be true.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,54 @@
+package router
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/openai/openai-go/option"
+)
+
+// ModelInfo describes a model served by the servers of a router.
+type ModelInfo struct {
+	ID      string   // ID is the logical model name.
+	Servers []string // Servers are the names of the servers that report serving the model.
+}
+
+// ListModels - Lists the models served by the servers of the router, as reported by the Models API of each server,
+// along with the servers that serve each of them. The servers are queried concurrently. When some of them fail,
+// the models of the other servers are returned along with an error joining the error of each failed server.
+func (r *Router) ListModels(ctx context.Context, opts ...option.RequestOption) ([]ModelInfo, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	servers := map[string][]string{}
+	errs := []error{}
+	for _, s := range r.serverList() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			models, err := s.ListModels(ctx, opts...)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+				return
+			}
+			for _, model := range models {
+				if !slices.Contains(servers[model], s.Name) {
+					servers[model] = append(servers[model], s.Name)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	models := make([]ModelInfo, 0, len(servers))
+	for _, id := range slices.Sorted(maps.Keys(servers)) {
+		models = append(models, ModelInfo{ID: id, Servers: slices.Sorted(slices.Values(servers[id]))})
+	}
+	slices.SortFunc(errs, func(a, b error) int { return cmp.Compare(a.Error(), b.Error()) })
+	return models, errors.Join(errs...)
+}
@@ -0,0 +1,77 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/acai-travel/go-openai-router/v2/pkg/server"
+	"github.com/openai/openai-go/option"
+)
+
+func TestListModels(t *testing.T) {
+	eastus := newModelsTestServer(t, "gpt4o-eastus", "whisper-1")
+	westeurope := newModelsTestServer(t, "gpt-4o", "gpt-4o-mini")
+	failing := newTestServer(t, http.StatusInternalServerError)
+	router, err := NewRouter([]server.ServerConfig{
+		{
+			Name:            "eastus",
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        eastus.URL,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			Deployments:     map[string]string{"gpt-4o": "gpt4o-eastus"},
+		},
+		{
+			Name:            "westeurope",
+			Type:            server.OpenAiServerType,
+			Endpoint:        westeurope.URL,
+			ApiKey:          "openai-key",
+			AvailableModels: []string{"gpt-4o"},
+		},
+		{
+			Name:            "failing",
+			Type:            server.OpenAiServerType,
+			Endpoint:        failing.URL,
+			ApiKey:          "openai-key",
+			AvailableModels: []string{"gpt-4o"},
+		},
+	}, RoundRobinStrategy)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	models, err := router.ListModels(context.TODO(), option.WithMaxRetries(0))
+	if err == nil || !strings.Contains(err.Error(), "failing") {
+		t.Fatalf("The error of the failing server should be returned, got %v", err)
+	}
+	expected := []ModelInfo{
+		{ID: "gpt-4o", Servers: []string{"eastus", "westeurope"}},
+		{ID: "gpt-4o-mini", Servers: []string{"westeurope"}},
+		{ID: "whisper-1", Servers: []string{"eastus"}},
+	}
+	if !reflect.DeepEqual(models, expected) {
+		t.Fatalf("Incorrect models %+v", models)
+	}
+}
+
+// newModelsTestServer returns a server whose Models API lists the given models.
+func newModelsTestServer(t *testing.T, models ...string) *httptest.Server {
+	t.Helper()
+	data := []string{}
+	for _, model := range models {
+		data = append(data, `{"id":"`+model+`","object":"model","created":1,"owned_by":"system"}`)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasSuffix(req.URL.Path, "/models") {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[` + strings.Join(data, ",") + `]}`))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
@@ -0,0 +1,149 @@
+package router
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+
+	"github.com/acai-travel/go-openai-router/v2/pkg/server"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// DefaultModerationModel is the model of the moderation requests that do not set one.
+const DefaultModerationModel = openai.ModerationModelOmniModerationLatest
+
+// ModerationConfig configures the moderation of the user messages of the chat completions, see WithModeration.
+type ModerationConfig struct {
+	Model string // Model is the moderation model, DefaultModerationModel when empty.
+	// FlagOnly sends the flagged requests anyway. The *ModerationError is set in the RouteInfo of the request
+	// instead of being returned.
+	FlagOnly bool
+	// FailOpen sends the requests whose moderation failed, instead of returning the error of the moderation.
+	FailOpen bool
+}
+
+// WithModeration moderates the user messages of every chat completion with the moderation models of the servers
+// before dispatching it. Flagged requests are rejected with a *ModerationError unless config.FlagOnly is set.
+func WithModeration(config ModerationConfig) RouterOption {
+	return func(r *Router) {
+		config.Model = cmp.Or(config.Model, DefaultModerationModel)
+		r.moderation = &config
+	}
+}
+
+// Moderate - Classifies if text and images are potentially harmful. The model of body defaults to
+// DefaultModerationModel, and only the servers that list it in their AvailableModels are used.
+// Servers are selected, failed over and fall back to other models like GetChatCompletions.
+func (r *Router) Moderate(ctx context.Context, body openai.ModerationNewParams, opts ...option.RequestOption) (*openai.ModerationNewResponse, error) {
+	r.requestCount.Add(1)
+	return r.dispatchModeration(ctx, body, opts...)
+}
+
+// dispatchModeration dispatches the moderation request body like Moderate, without counting it as a request
+// of the router.
+func (r *Router) dispatchModeration(ctx context.Context, body openai.ModerationNewParams, opts ...option.RequestOption) (*openai.ModerationNewResponse, error) {
+	modelName := cmp.Or(body.Model.String(), DefaultModerationModel)
+	return dispatchWithFallbacks(ctx, r, Request{Model: modelName, Params: body}, func(s *server.RouterServer, modelName string) (*openai.ModerationNewResponse, error) {
+		body.Model = openai.F(openai.ModerationModel(s.DeploymentName(modelName)))
+		return s.NewModeration(ctx, body, opts...)
+	})
+}
+
+// moderate runs the moderation configured with WithModeration on the user messages of body.
+// It returns the error the request must be rejected with, or nil if it can be sent.
+func (r *Router) moderate(ctx context.Context, body openai.ChatCompletionNewParams) error {
+	if r.moderation == nil {
+		return nil
+	}
+	inputs := userMessages(body)
+	if len(inputs) == 0 {
+		return nil
+	}
+	// The moderation request is part of the request it moderates: it is not counted as a request of the router
+	// and must not fill its RouteInfo.
+	moderation, err := r.dispatchModeration(ContextWithRouteInfo(ctx, nil), openai.ModerationNewParams{
+		Input: openai.F[openai.ModerationNewParamsInputUnion](openai.ModerationNewParamsInputArray(inputs)),
+		Model: openai.F(r.moderation.Model),
+	})
+	if err != nil {
+		if r.moderation.FailOpen {
+			slog.Warn("Moderation failed, sending the request anyway", "error", err)
+			return nil
+		}
+		return err
+	}
+	moderationErr := newModerationError(moderation)
+	if moderationErr == nil {
+		return nil
+	}
+	if r.moderation.FlagOnly {
+		if info := routeInfoFromContext(ctx); info != nil {
+			info.Moderation = moderationErr
+		}
+		return nil
+	}
+	return moderationErr
+}
+
+// userMessages returns the text of the user messages of body.
+func userMessages(body openai.ChatCompletionNewParams) []string {
+	texts := []string{}
+	for _, message := range body.Messages.Value {
+		data, err := json.Marshal(message)
+		if err != nil {
+			continue
+		}
+		var user struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		}
+		if err := json.Unmarshal(data, &user); err != nil || user.Role != "user" {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal(user.Content, &text); err == nil {
+			texts = append(texts, text)
+			continue
+		}
+		var parts []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(user.Content, &parts); err != nil {
+			continue
+		}
+		for _, part := range parts {
+			if part.Type == "text" {
+				texts = append(texts, part.Text)
+			}
+		}
+	}
+	return texts
+}
+
+// newModerationError returns the error of a moderation that flagged its input, or nil.
+func newModerationError(moderation *openai.ModerationNewResponse) *ModerationError {
+	flagged := false
+	categories := []string{}
+	for _, result := range moderation.Results {
+		if !result.Flagged {
+			continue
+		}
+		flagged = true
+		resultCategories := map[string]bool{}
+		json.Unmarshal([]byte(result.Categories.JSON.RawJSON()), &resultCategories)
+		for category, ok := range resultCategories {
+			if ok && !slices.Contains(categories, category) {
+				categories = append(categories, category)
+			}
+		}
+	}
+	if !flagged {
+		return nil
+	}
+	slices.Sort(categories)
+	return &ModerationError{Model: moderation.Model, Categories: categories, Results: moderation.Results}
+}
@@ -0,0 +1,130 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/shared"
+)
+
+func TestModerate(t *testing.T) {
+	failing, failingCount := newCountingTestServer(t, http.StatusServiceUnavailable)
+	ts, _ := newModerationTestServer(t)
+	router := getRouterForModels(t, RoundRobinStrategy, []string{"gpt-3.5-turbo", DefaultModerationModel}, []string{failing.URL, ts.URL})
+	moderation, err := router.Moderate(context.TODO(), openai.ModerationNewParams{
+		Input: openai.F[openai.ModerationNewParamsInputUnion](shared.UnionString("I will kill you")),
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if failingCount.Load() != 1 || len(moderation.Results) != 1 || !moderation.Results[0].Flagged {
+		t.Fatalf("Incorrect moderation %+v", moderation)
+	}
+}
+
+func TestModerationRejects(t *testing.T) {
+	ts, moderations := newModerationTestServer(t)
+	router := getRouterForModels(t, RoundRobinStrategy, []string{"gpt-3.5-turbo", DefaultModerationModel}, []string{ts.URL}, WithModeration(ModerationConfig{}))
+	_, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModel("gpt-3.5-turbo")),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage("You kill bugs"),
+			openai.UserMessage("I will kill you"),
+		}),
+	})
+	var moderationErr *ModerationError
+	if !errors.As(err, &moderationErr) || !slices.Equal(moderationErr.Categories, []string{"violence"}) {
+		t.Fatalf("A flagged request should be rejected with a ModerationError, got %v", err)
+	}
+	if moderations.Load() != 1 {
+		t.Fatalf("Incorrect number of moderations %d", moderations.Load())
+	}
+
+	completion, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+		Model:    openai.F(openai.ChatModel("gpt-3.5-turbo")),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("Who wrote the Jungle Book?")}),
+	})
+	if err != nil || completion.ID != "chatcmpl-test" {
+		t.Fatalf("A request that is not flagged should be sent, got %v", err)
+	}
+	if router.requestCount.Load() != 2 {
+		t.Fatalf("The moderations should not be counted as requests, got %d requests", router.requestCount.Load())
+	}
+}
+
+func TestModerationFlagOnly(t *testing.T) {
+	ts, _ := newModerationTestServer(t)
+	router := getRouterForModels(t, RoundRobinStrategy, []string{"gpt-3.5-turbo", DefaultModerationModel}, []string{ts.URL}, WithModeration(ModerationConfig{FlagOnly: true}))
+	info := &RouteInfo{}
+	_, err := router.GetChatCompletionsStream(ContextWithRouteInfo(context.TODO(), info), openai.ChatCompletionNewParams{
+		Model:    openai.F(openai.ChatModel("gpt-3.5-turbo")),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("I will kill you")}),
+	})
+	if err != nil {
+		t.Fatalf("A flagged request should be sent, got %v", err)
+	}
+	if info.Moderation == nil || info.Model != "gpt-3.5-turbo" {
+		t.Fatalf("The moderation should be reported in the route info, got %+v", info)
+	}
+}
+
+func TestModerationFailure(t *testing.T) {
+	ts := newTestServer(t, http.StatusOK)
+	body := openai.ChatCompletionNewParams{
+		Model:    openai.F(openai.ChatModel("gpt-3.5-turbo")),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hi")}),
+	}
+	// No server serves the moderation model.
+	router := getRouterForEndpoints(t, RoundRobinStrategy, ts.URL)
+	WithModeration(ModerationConfig{})(router)
+	if _, err := router.GetChatCompletions(context.TODO(), body); !errors.Is(err, ErrNoServerAvailable) {
+		t.Fatalf("The error of the moderation should be returned, got %v", err)
+	}
+	WithModeration(ModerationConfig{FailOpen: true})(router)
+	if _, err := router.GetChatCompletions(context.TODO(), body); err != nil {
+		t.Fatalf("The request should be sent when the moderation fails open, got %v", err)
+	}
+}
+
+// newModerationTestServer returns a server that flags the inputs containing "kill" as violence and otherwise
+// behaves like newTestServer, and the number of moderations it received.
+func newModerationTestServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	count := &atomic.Int32{}
+	handler := testHandler(http.StatusOK)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasSuffix(req.URL.Path, "/moderations") {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		count.Add(1)
+		data, _ := io.ReadAll(req.Body)
+		var body struct {
+			Input any `json:"input"`
+		}
+		json.Unmarshal(data, &body)
+		inputs, ok := body.Input.([]any)
+		if !ok {
+			inputs = []any{body.Input}
+		}
+		results := []string{}
+		for _, input := range inputs {
+			flagged := strings.Contains(input.(string), "kill")
+			results = append(results, `{"flagged":`+strconv.FormatBool(flagged)+`,"categories":{"violence":`+strconv.FormatBool(flagged)+`,"harassment":false}}`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"modr-test","model":"omni-moderation-latest","results":[` + strings.Join(results, ",") + `]}`))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, count
+}
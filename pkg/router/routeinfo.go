@@ -0,0 +1,25 @@
+package router
+
+import "context"
+
+type routeInfoKey struct{}
+
+// RouteInfo describes how the router served a request.
+type RouteInfo struct {
+	Model    string    // Model is the logical model that served the request, a fallback model if the requested one failed.
+	Server   string    // Server is the endpoint of the server that served the request.
+	Attempts []Attempt // Attempts are the failed attempts that preceded the one that served the request.
+	// Moderation is set when the moderation configured with ModerationConfig.FlagOnly flagged the request.
+	Moderation *ModerationError
+}
+
+// ContextWithRouteInfo returns a copy of ctx that makes the router fill info when it serves a request made with it.
+func ContextWithRouteInfo(ctx context.Context, info *RouteInfo) context.Context {
+	return context.WithValue(ctx, routeInfoKey{}, info)
+}
+
+// routeInfoFromContext returns the RouteInfo registered with ContextWithRouteInfo, or nil.
+func routeInfoFromContext(ctx context.Context) *RouteInfo {
+	info, _ := ctx.Value(routeInfoKey{}).(*RouteInfo)
+	return info
+}
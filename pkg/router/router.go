@@ -2,8 +2,13 @@ package router
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/acai-travel/go-openai-router/v2/pkg/server"
 	"github.com/openai/openai-go"
@@ -11,63 +16,362 @@ import (
 	"github.com/openai/openai-go/packages/ssestream"
 )
 
+// DefaultMaxAttempts is the number of servers a request is dispatched to before giving up.
+const DefaultMaxAttempts = 3
+
+// Router dispatches requests to a set of servers using a strategy.
+// It is safe for concurrent use by multiple goroutines.
 type Router struct {
+	serversMu    sync.RWMutex // serversMu guards servers, which is replaced instead of being modified.
 	servers      []*server.RouterServer
 	serverCount  int
-	requestCount int
-	strategy     routerStrategy
+	requestCount atomic.Int64
+	strategy     Strategy
+	maxAttempts  atomic.Int64
+	fallbacks    atomic.Pointer[map[string][]string]
+	moderation   *ModerationConfig
+	healthChecks time.Duration
+	closeOnce    sync.Once
+	closed       chan struct{}
+}
+
+// RouterOption configures optional behaviour of a Router.
+type RouterOption func(*Router)
+
+// WithMaxAttempts sets the maximum number of servers a single request is dispatched to when the previous
+// servers returned a retryable error. Values lower than 1 are ignored.
+func WithMaxAttempts(maxAttempts int) RouterOption {
+	return func(r *Router) {
+		if maxAttempts > 0 {
+			r.maxAttempts.Store(int64(maxAttempts))
+		}
+	}
+}
+
+// WithFallbacks sets the ordered fallback models of each model, for example "gpt-4o" to
+// ["gpt-4o-mini", "gpt-35-turbo"]. A fallback model is used when the previous models have no server available
+// or failed on every attempt with a retryable error. Use ContextWithRouteInfo to know which model served a request.
+func WithFallbacks(fallbacks map[string][]string) RouterOption {
+	return func(r *Router) {
+		r.SetFallbacks(fallbacks)
+	}
+}
+
+// SetMaxAttempts changes the maximum number of servers a single request is dispatched to, see WithMaxAttempts,
+// while the router serves requests. Values lower than 1 restore DefaultMaxAttempts. The requests that are already
+// being dispatched keep the previous value.
+func (r *Router) SetMaxAttempts(maxAttempts int) {
+	if maxAttempts < 1 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	r.maxAttempts.Store(int64(maxAttempts))
+}
+
+// SetFallbacks replaces the fallback models of each model, see WithFallbacks, while the router serves requests.
+// The requests that are already being dispatched keep the previous fallbacks.
+func (r *Router) SetFallbacks(fallbacks map[string][]string) {
+	r.fallbacks.Store(&fallbacks)
+}
+
+// fallbacksOf returns the fallback models of modelName.
+func (r *Router) fallbacksOf(modelName string) []string {
+	fallbacks := r.fallbacks.Load()
+	if fallbacks == nil {
+		return nil
+	}
+	return (*fallbacks)[modelName]
 }
 
 // NewRouter creates a new Router instance with the given server configurations and strategy type.
 // It returns a pointer to the Router and an error if any.
 // The serverConfigs parameter is a slice of server.ServerConfig that contains the configurations for each server.
-// The strategyType parameter is the type of router strategy to be used.
-// If the serverConfigs slice is empty, it returns an error with the message "empty server config".
+// The strategyType parameter is the type of router strategy to be used, a built-in one or one added with RegisterStrategy.
+// If the serverConfigs slice is empty, it returns an error with the message "empty server config", and the errors
+// of the invalid server configurations are prefixed with the name of the server.
 // Otherwise, it creates a new RouterServer for each server configuration and adds them to the servers slice.
-// Finally, it initializes the Router with the servers, serverCount, requestCount, and strategy.
-func NewRouter(serverConfigs []server.ServerConfig, strategyType RouterStrategyType) (*Router, error) {
-	servers := []*server.RouterServer{}
+// Finally, it initializes the Router with the servers, serverCount, requestCount, and strategy, and applies the opts.
+func NewRouter(serverConfigs []server.ServerConfig, strategyType RouterStrategyType, opts ...RouterOption) (*Router, error) {
+	slog.Debug("Creating New Router", "strategy", strategyType)
+	return NewRouterWithStrategy(serverConfigs, newRouterStrategy(strategyType), opts...)
+}
+
+// NewRouterWithStrategy creates a new Router like NewRouter, selecting servers with the given strategy.
+// Use it to plug in a custom Strategy without registering it.
+func NewRouterWithStrategy(serverConfigs []server.ServerConfig, strategy Strategy, opts ...RouterOption) (*Router, error) {
 	if len(serverConfigs) == 0 {
 		return nil, fmt.Errorf("empty server config")
 	}
-	for _, serverConfig := range serverConfigs {
-		server, err := server.NewRouterServer(serverConfig)
-		if err != nil {
-			return nil, err
-		}
-		servers = append(servers, server)
+	servers, err := newServers(serverConfigs)
+	if err != nil {
+		return nil, err
 	}
-	slog.Debug("Creating New Router", "strategy", strategyType)
-	return &Router{
-		servers:      servers,
-		serverCount:  len(servers),
-		requestCount: 0,
-		strategy:     newRouterStrategy(strategyType),
-	}, nil
+	router := &Router{
+		servers:     servers,
+		serverCount: len(servers),
+		strategy:    strategy,
+		closed:      make(chan struct{}),
+	}
+	router.maxAttempts.Store(DefaultMaxAttempts)
+	for _, opt := range opts {
+		opt(router)
+	}
+	if router.healthChecks > 0 {
+		go router.checkHealthPeriodically(router.healthChecks)
+	}
+	return router, nil
+}
+
+// Close stops the background work of the router and of its servers, such as the health checks and the discovery
+// of their models. Requests can still be routed by a closed router.
+func (r *Router) Close() {
+	r.closeOnce.Do(func() { close(r.closed) })
+	closeServers(r.serverList())
 }
 
 // GetChatCompletions - Gets chat completions for the provided chat messages. Completions support a wide variety of tasks
 // and generate text that continues from or "completes" provided prompt data.
-// If the operation fails it returns an *azcore.ResponseError type.
+// The model of body is the logical model name, which is rewritten to the deployment name of the selected server.
+// Retryable errors are failed over to the next eligible server, and then to the fallback models. If every attempt
+// fails it returns a *FailoverError that wraps the *openai.Error of each attempt.
+// With WithModeration, requests whose user messages are flagged are rejected with a *ModerationError.
 func (r *Router) GetChatCompletions(ctx context.Context, body openai.ChatCompletionNewParams, opts ...option.RequestOption) (*openai.ChatCompletion, error) {
-	r.requestCount++
-	modelName := body.Model.String()
-	server := r.strategy.GetAvailableServer(r, modelName)
-	if server == nil {
-		return nil, fmt.Errorf("no server available for model %s", modelName)
+	r.requestCount.Add(1)
+	if err := r.moderate(ctx, body); err != nil {
+		return nil, err
 	}
-	return server.NewCompletion(ctx, body, opts...)
+	return dispatchWithFallbacks(ctx, r, Request{Model: body.Model.String(), Params: body}, func(s *server.RouterServer, modelName string) (*openai.ChatCompletion, error) {
+		return s.NewCompletion(ctx, withDeployment(body, s, modelName), opts...)
+	})
 }
 
 // GetChatCompletionsStream - Return the chat completions for a given prompt as a sequence of events.
-// If the operation fails it returns an *azcore.ResponseError type.
-//   - options - GetCompletionsOptions contains the optional parameters for the Client.GetCompletions method.
+// Servers that fail to open the stream with a retryable error are failed over like GetChatCompletions.
+// Once the stream is returned, errors that happen while reading it are reported by the stream itself.
+// Requests are moderated like GetChatCompletions.
 func (r *Router) GetChatCompletionsStream(ctx context.Context, body openai.ChatCompletionNewParams, opts ...option.RequestOption) (*ssestream.Stream[openai.ChatCompletionChunk], error) {
-	r.requestCount++
-	modelName := body.Model.String()
-	server := r.strategy.GetAvailableServer(r, modelName)
-	if server == nil {
-		return nil, fmt.Errorf("no server available for model %s", modelName)
+	r.requestCount.Add(1)
+	if err := r.moderate(ctx, body); err != nil {
+		return nil, err
+	}
+	return dispatchWithFallbacks(ctx, r, Request{Model: body.Model.String(), Params: body}, func(s *server.RouterServer, modelName string) (*ssestream.Stream[openai.ChatCompletionChunk], error) {
+		stream := s.NewStreamingCompletion(ctx, withDeployment(body, s, modelName), opts...)
+		if err := stream.Err(); err != nil {
+			stream.Close()
+			return nil, err
+		}
+		return stream, nil
+	})
+}
+
+// GetCompletions - Gets the completion of a prompt with the legacy completions endpoint, for models such as
+// gpt-3.5-turbo-instruct. The model of body is the logical model name, which is rewritten to the deployment name
+// of the selected server. Servers are selected, failed over and fall back to other models like GetChatCompletions.
+func (r *Router) GetCompletions(ctx context.Context, body openai.CompletionNewParams, opts ...option.RequestOption) (*openai.Completion, error) {
+	r.requestCount.Add(1)
+	return dispatchWithFallbacks(ctx, r, Request{Model: body.Model.String(), Params: body}, func(s *server.RouterServer, modelName string) (*openai.Completion, error) {
+		body.Model = openai.F(openai.CompletionNewParamsModel(s.DeploymentName(modelName)))
+		return s.NewTextCompletion(ctx, body, opts...)
+	})
+}
+
+// GetEmbeddings - Return the embeddings for the given input, for example to index documents.
+// The model of body is the logical model name, which is rewritten to the deployment name of the selected server.
+// Servers are selected, failed over and fall back to other models like GetChatCompletions.
+func (r *Router) GetEmbeddings(ctx context.Context, body openai.EmbeddingNewParams, opts ...option.RequestOption) (*openai.CreateEmbeddingResponse, error) {
+	r.requestCount.Add(1)
+	return dispatchWithFallbacks(ctx, r, Request{Model: body.Model.String(), Params: body}, func(s *server.RouterServer, modelName string) (*openai.CreateEmbeddingResponse, error) {
+		body.Model = openai.F(openai.EmbeddingModel(s.DeploymentName(modelName)))
+		return s.NewEmbedding(ctx, body, opts...)
+	})
+}
+
+// GenerateImages - Creates images given a prompt.
+// The model of body is the logical model name and must be set to select the servers that serve it. It is rewritten
+// to the deployment name of the selected server. Servers are selected, failed over and fall back to other models
+// like GetChatCompletions.
+func (r *Router) GenerateImages(ctx context.Context, body openai.ImageGenerateParams, opts ...option.RequestOption) (*openai.ImagesResponse, error) {
+	r.requestCount.Add(1)
+	return dispatchWithFallbacks(ctx, r, Request{Model: body.Model.String(), Params: body}, func(s *server.RouterServer, modelName string) (*openai.ImagesResponse, error) {
+		body.Model = openai.F(openai.ImageModel(s.DeploymentName(modelName)))
+		return s.GenerateImages(ctx, body, opts...)
+	})
+}
+
+// GetAudioTranscription - Transcribes audio into the input language.
+// The audio file is read once before the request is sent, so that it can be sent again to another server.
+// Servers are selected, failed over and fall back to other models like GetChatCompletions.
+func (r *Router) GetAudioTranscription(ctx context.Context, body openai.AudioTranscriptionNewParams, opts ...option.RequestOption) (*openai.Transcription, error) {
+	r.requestCount.Add(1)
+	file, err := newReplayableFile(body.File.Value)
+	if err != nil {
+		return nil, err
+	}
+	return dispatchWithFallbacks(ctx, r, Request{Model: body.Model.String(), Params: body}, func(s *server.RouterServer, modelName string) (*openai.Transcription, error) {
+		body.Model = openai.F(openai.AudioModel(s.DeploymentName(modelName)))
+		body.File = openai.F(file.reader())
+		return s.NewTranscription(ctx, body, opts...)
+	})
+}
+
+// GetAudioTranslation - Translates audio into English.
+// The audio file is read once before the request is sent, so that it can be sent again to another server.
+// Servers are selected, failed over and fall back to other models like GetChatCompletions.
+func (r *Router) GetAudioTranslation(ctx context.Context, body openai.AudioTranslationNewParams, opts ...option.RequestOption) (*openai.Translation, error) {
+	r.requestCount.Add(1)
+	file, err := newReplayableFile(body.File.Value)
+	if err != nil {
+		return nil, err
+	}
+	return dispatchWithFallbacks(ctx, r, Request{Model: body.Model.String(), Params: body}, func(s *server.RouterServer, modelName string) (*openai.Translation, error) {
+		body.Model = openai.F(openai.AudioModel(s.DeploymentName(modelName)))
+		body.File = openai.F(file.reader())
+		return s.NewTranslation(ctx, body, opts...)
+	})
+}
+
+// GetSpeech - Generates audio from the input text. The body of the returned response is the audio and must be
+// closed by the caller.
+// Servers are selected, failed over and fall back to other models like GetChatCompletions.
+func (r *Router) GetSpeech(ctx context.Context, body openai.AudioSpeechNewParams, opts ...option.RequestOption) (*http.Response, error) {
+	r.requestCount.Add(1)
+	return dispatchWithFallbacks(ctx, r, Request{Model: body.Model.String(), Params: body}, func(s *server.RouterServer, modelName string) (*http.Response, error) {
+		body.Model = openai.F(openai.SpeechModel(s.DeploymentName(modelName)))
+		return s.NewSpeech(ctx, body, opts...)
+	})
+}
+
+// CreateResponse - Creates a response with the Responses API, for example to use file search or reasoning summaries.
+// The model of body is the logical model name, which is rewritten to the deployment name of the selected server.
+// Servers are selected, failed over and fall back to other models like GetChatCompletions, except for the requests that
+// continue a response with PreviousResponseID: they are only sent to the server that created that response, as long
+// as the server remembers it.
+func (r *Router) CreateResponse(ctx context.Context, body server.ResponseNewParams, opts ...option.RequestOption) (*server.Response, error) {
+	r.requestCount.Add(1)
+	req := Request{Model: body.Model, Params: body, pinned: r.responseOwner(body.PreviousResponseID)}
+	return dispatchWithFallbacks(ctx, r, req, func(s *server.RouterServer, modelName string) (*server.Response, error) {
+		body.Model = s.DeploymentName(modelName)
+		return s.NewResponse(ctx, body, opts...)
+	})
+}
+
+// CreateResponseStream - Return a response created with the Responses API as a sequence of events.
+// Servers are selected like CreateResponse and the stream is failed over like GetChatCompletionsStream.
+func (r *Router) CreateResponseStream(ctx context.Context, body server.ResponseNewParams, opts ...option.RequestOption) (*ssestream.Stream[server.ResponseStreamEvent], error) {
+	r.requestCount.Add(1)
+	req := Request{Model: body.Model, Params: body, pinned: r.responseOwner(body.PreviousResponseID)}
+	return dispatchWithFallbacks(ctx, r, req, func(s *server.RouterServer, modelName string) (*ssestream.Stream[server.ResponseStreamEvent], error) {
+		body.Model = s.DeploymentName(modelName)
+		stream := s.NewStreamingResponse(ctx, body, opts...)
+		if err := stream.Err(); err != nil {
+			stream.Close()
+			return nil, err
+		}
+		return stream, nil
+	})
+}
+
+// responseOwner returns the server that created the response with the given id, or nil if no server remembers it.
+func (r *Router) responseOwner(id string) *server.RouterServer {
+	if id == "" {
+		return nil
+	}
+	for _, s := range r.serverList() {
+		if s.OwnsResponse(id) {
+			return s
+		}
+	}
+	return nil
+}
+
+// withDeployment returns a copy of body whose model is the deployment name of modelName on server s.
+func withDeployment(body openai.ChatCompletionNewParams, s *server.RouterServer, modelName string) openai.ChatCompletionNewParams {
+	body.Model = openai.F(openai.ChatModel(s.DeploymentName(modelName)))
+	return body
+}
+
+// dispatchWithFallbacks dispatches req and, when it cannot be served, dispatches it again for each of the
+// fallback models of its model in order. It returns the error of the last model if none of them served the request.
+func dispatchWithFallbacks[T any](ctx context.Context, r *Router, req Request, call func(s *server.RouterServer, modelName string) (T, error)) (T, error) {
+	modelName := req.Model
+	res, err := dispatch(ctx, r, req, call)
+	for _, fallback := range r.fallbacksOf(modelName) {
+		if !shouldFallback(err) || ctx.Err() != nil {
+			break
+		}
+		slog.Debug("Falling back to another model", "model", modelName, "fallback", fallback, "error", err)
+		req.Model = fallback
+		res, err = dispatch(ctx, r, req, call)
+	}
+	return res, err
+}
+
+// shouldFallback reports whether a request that failed with err can be sent to a fallback model.
+func shouldFallback(err error) bool {
+	var failoverErr *FailoverError
+	if errors.As(err, &failoverErr) {
+		return IsRetryable(failoverErr.Attempts[len(failoverErr.Attempts)-1].Err)
+	}
+	return errors.Is(err, ErrNoServerAvailable)
+}
+
+// selectServer returns the server the router strategy picks for req among the available servers
+// that are not part of excluded, or nil if there is none. A request pinned to a server can only be sent to it.
+func (r *Router) selectServer(ctx context.Context, req Request, excluded []*server.RouterServer) *server.RouterServer {
+	servers := r.serverList()
+	if req.pinned != nil {
+		servers = []*server.RouterServer{req.pinned}
+	}
+	filteredServers := filterServers(servers, req.Model, excluded)
+	if len(filteredServers) == 0 {
+		return nil
+	}
+	candidates := make([]ServerSnapshot, 0, len(filteredServers))
+	for _, s := range filteredServers {
+		candidates = append(candidates, newServerSnapshot(s, req.Model))
+	}
+	index := r.strategy.SelectServer(ctx, req, candidates)
+	if index < 0 || index >= len(candidates) {
+		return nil
+	}
+	return filteredServers[index]
+}
+
+// dispatch sends req to the server picked by the router strategy using call.
+// When call fails with a retryable error the request is sent to another eligible server that has not been tried yet,
+// up to the router's maxAttempts. The outcome is recorded in the RouteInfo of ctx, if any.
+func dispatch[T any](ctx context.Context, r *Router, req Request, call func(s *server.RouterServer, modelName string) (T, error)) (T, error) {
+	var zero T
+	modelName := req.Model
+	info := routeInfoFromContext(ctx)
+	tried := []*server.RouterServer{}
+	attempts := []Attempt{}
+	maxAttempts := int(r.maxAttempts.Load())
+	for len(attempts) < maxAttempts {
+		server := r.selectServer(ctx, req, tried)
+		if server == nil {
+			break
+		}
+		tried = append(tried, server)
+		res, err := call(server, modelName)
+		if err == nil {
+			if info != nil {
+				info.Model = modelName
+				info.Server = server.Endpoint
+			}
+			return res, nil
+		}
+		attempt := Attempt{Model: modelName, Server: server.Endpoint, Err: err}
+		attempts = append(attempts, attempt)
+		if info != nil {
+			info.Attempts = append(info.Attempts, attempt)
+		}
+		if !IsRetryable(err) || ctx.Err() != nil {
+			break
+		}
+		slog.Debug("Retrying request on another server", "model", modelName, "server", server.Endpoint, "attempt", len(attempts), "error", err)
+	}
+	if len(attempts) == 0 {
+		return zero, fmt.Errorf("%w for model %s", ErrNoServerAvailable, modelName)
 	}
-	return server.NewStreamingCompletion(ctx, body, opts...), nil
+	return zero, &FailoverError{Model: modelName, Attempts: attempts}
 }
@@ -2,10 +2,21 @@ package router
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/acai-travel/go-openai-router/v2/pkg/server"
 	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
 )
 
 func TestNewRouter(t *testing.T) {
@@ -26,14 +37,43 @@ func TestNewRouter(t *testing.T) {
 	}
 }
 
+func TestNewRouterClosesServers(t *testing.T) {
+	var discoveries atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		discoveries.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"llama3","object":"model"}]}`))
+	}))
+	defer ts.Close()
+	_, err := NewRouter([]server.ServerConfig{
+		{
+			Type:      server.OpenAiCompatibleServerType,
+			Endpoint:  ts.URL,
+			Discovery: server.ModelDiscovery{Enabled: true, Interval: 5 * time.Millisecond},
+		},
+		{
+			Type: "foo",
+		},
+	}, RoundRobinStrategy)
+	if err == nil {
+		t.Fatal("NewRouter should have errored for incorrect type in server config")
+	}
+	// A discovery may still be in flight when the server is closed.
+	count := discoveries.Load() + 1
+	time.Sleep(50 * time.Millisecond)
+	if discoveries.Load() > count {
+		t.Fatalf("The servers created before the error should be closed, got %d discoveries", discoveries.Load())
+	}
+}
+
 func TestGetChatCompletions(t *testing.T) {
 	router := getRouter()
 	deploymentName := openai.ChatModelGPT3_5Turbo
 	router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
 		Model: openai.F(deploymentName),
 	})
-	if router.requestCount != 1 {
-		t.Fatalf("Incorrect requests count %d", router.requestCount)
+	if router.requestCount.Load() != 1 {
+		t.Fatalf("Incorrect requests count %d", router.requestCount.Load())
 	}
 }
 
@@ -43,8 +83,8 @@ func TestGetChatCompletionsStream(t *testing.T) {
 	router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
 		Model: openai.F(deploymentName),
 	})
-	if router.requestCount != 1 {
-		t.Fatalf("Incorrect requests count %d", router.requestCount)
+	if router.requestCount.Load() != 1 {
+		t.Fatalf("Incorrect requests count %d", router.requestCount.Load())
 	}
 }
 
@@ -59,3 +99,606 @@ func getRouter() *Router {
 	}, RoundRobinStrategy)
 	return router
 }
+
+func TestGetChatCompletionsFailover(t *testing.T) {
+	failing, failingCount := newCountingTestServer(t, http.StatusServiceUnavailable)
+	healthy := newTestServer(t, http.StatusOK)
+	router := getRouterForEndpoints(t, RoundRobinStrategy, failing.URL, healthy.URL)
+
+	// The router retries on another server, even when the caller asks the openai client to retry.
+	completion, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT3_5Turbo),
+	}, option.WithMaxRetries(2))
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if completion.ID != "chatcmpl-test" {
+		t.Fatalf("Incorrect completion returned %s", completion.ID)
+	}
+	if failingCount.Load() != 1 {
+		t.Fatalf("The failing server should be tried once, got %d requests", failingCount.Load())
+	}
+}
+
+func TestGetChatCompletionsFailoverExhausted(t *testing.T) {
+	first := newTestServer(t, http.StatusTooManyRequests)
+	second := newTestServer(t, http.StatusBadGateway)
+	router := getRouterForEndpoints(t, RoundRobinStrategy, first.URL, second.URL)
+
+	_, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT3_5Turbo),
+	})
+	var failoverErr *FailoverError
+	if !errors.As(err, &failoverErr) {
+		t.Fatalf("Expected a FailoverError but got %v", err)
+	}
+	if len(failoverErr.Attempts) != 2 {
+		t.Fatalf("Incorrect number of attempts %d", len(failoverErr.Attempts))
+	}
+	if failoverErr.Attempts[0].Server == failoverErr.Attempts[1].Server {
+		t.Fatal("The same server was tried twice")
+	}
+	var apiErr *openai.Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected the FailoverError to wrap an openai.Error but got %v", err)
+	}
+}
+
+func TestGetChatCompletionsNoFailoverOnClientError(t *testing.T) {
+	first := newTestServer(t, http.StatusBadRequest)
+	second := newTestServer(t, http.StatusBadRequest)
+	router := getRouterForEndpoints(t, RoundRobinStrategy, first.URL, second.URL)
+
+	_, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT3_5Turbo),
+	})
+	var failoverErr *FailoverError
+	if !errors.As(err, &failoverErr) {
+		t.Fatalf("Expected a FailoverError but got %v", err)
+	}
+	if len(failoverErr.Attempts) != 1 {
+		t.Fatalf("A non retryable error should not be failed over, got %d attempts", len(failoverErr.Attempts))
+	}
+}
+
+func TestGetChatCompletionsMaxAttempts(t *testing.T) {
+	endpoints := []string{}
+	for i := 0; i < 3; i++ {
+		endpoints = append(endpoints, newTestServer(t, http.StatusInternalServerError).URL)
+	}
+	router := getRouterForEndpoints(t, RoundRobinStrategy, endpoints...)
+	WithMaxAttempts(2)(router)
+
+	_, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT3_5Turbo),
+	})
+	var failoverErr *FailoverError
+	if !errors.As(err, &failoverErr) || len(failoverErr.Attempts) != 2 {
+		t.Fatalf("Expected 2 attempts but got %v", err)
+	}
+
+	router.SetMaxAttempts(0)
+	_, err = router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT3_5Turbo),
+	})
+	if !errors.As(err, &failoverErr) || len(failoverErr.Attempts) != DefaultMaxAttempts {
+		t.Fatalf("Expected %d attempts but got %v", DefaultMaxAttempts, err)
+	}
+}
+
+func TestGetChatCompletionsNoServer(t *testing.T) {
+	router := getRouter()
+	_, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F("model-not-available"),
+	})
+	if !errors.Is(err, ErrNoServerAvailable) {
+		t.Fatalf("Expected ErrNoServerAvailable but got %v", err)
+	}
+}
+
+func TestGetChatCompletionsStreamFailover(t *testing.T) {
+	failing := newTestServer(t, http.StatusServiceUnavailable)
+	healthy := newTestServer(t, http.StatusOK)
+	router := getRouterForEndpoints(t, RoundRobinStrategy, failing.URL, healthy.URL)
+
+	stream, err := router.GetChatCompletionsStream(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT3_5Turbo),
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer stream.Close()
+	chunks := 0
+	for stream.Next() {
+		chunks++
+	}
+	if stream.Err() != nil || chunks != 1 {
+		t.Fatalf("Incorrect stream returned, chunks %d, error %v", chunks, stream.Err())
+	}
+}
+
+// newTestServer starts an OpenAI compatible server that answers every request with the given status code.
+func newTestServer(t *testing.T, statusCode int) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(testHandler(statusCode))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// newCountingTestServer is like newTestServer and also counts the requests the server received.
+func newCountingTestServer(t *testing.T, statusCode int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	count := &atomic.Int32{}
+	handler := testHandler(statusCode)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		count.Add(1)
+		handler.ServeHTTP(w, req)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, count
+}
+
+func testHandler(statusCode int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if statusCode != http.StatusOK {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statusCode)
+			w.Write([]byte(`{"error":{"message":"test error","type":"test"}}`))
+			return
+		}
+		if strings.HasSuffix(req.URL.Path, "/responses") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"resp_` + req.Host + `","object":"response","status":"completed","output":[],"usage":{"input_tokens":5,"output_tokens":1,"total_tokens":6}}`))
+			return
+		}
+		if strings.HasSuffix(req.URL.Path, "/images/generations") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"created":1,"data":[{"url":"https://example.com/image.png"}]}`))
+			return
+		}
+		if strings.HasSuffix(req.URL.Path, "/audio/speech") {
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write([]byte("audio"))
+			return
+		}
+		if strings.HasSuffix(req.URL.Path, "/completions") && !strings.HasSuffix(req.URL.Path, "/chat/completions") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"cmpl-test","object":"text_completion","model":"gpt-35-turbo-instruct","choices":[{"index":0,"finish_reason":"stop","text":"Kipling"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`))
+			return
+		}
+		if strings.HasSuffix(req.URL.Path, "/embeddings") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"object":"list","model":"text-embedding-3-small","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]}],"usage":{"prompt_tokens":2,"total_tokens":2}}`))
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		if strings.Contains(string(body), `"stream":true`) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\n"))
+			w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-test","object":"chat.completion","model":"gpt-3.5-turbo","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"Hi"}}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`))
+	})
+}
+
+// getRouterForEndpoints creates a router with one Azure server per endpoint, all serving gpt-3.5-turbo.
+func getRouterForEndpoints(t *testing.T, strategyType RouterStrategyType, endpoints ...string) *Router {
+	t.Helper()
+	return getRouterForModels(t, strategyType, []string{"gpt-3.5-turbo"}, endpoints)
+}
+
+// getRouterForModels creates a router with one Azure server per endpoint, all serving models.
+func getRouterForModels(t *testing.T, strategyType RouterStrategyType, models []string, endpoints []string, opts ...RouterOption) *Router {
+	t.Helper()
+	serverConfigs := []server.ServerConfig{}
+	for _, endpoint := range endpoints {
+		serverConfigs = append(serverConfigs, getTestServerConfig(endpoint, models...))
+	}
+	router, err := NewRouter(serverConfigs, strategyType, opts...)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	return router
+}
+
+// getTestServerConfig returns the configuration of an Azure server serving models.
+func getTestServerConfig(endpoint string, models ...string) server.ServerConfig {
+	return server.ServerConfig{
+		Type:            server.AzureOpenAiServerType,
+		Endpoint:        endpoint,
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		AvailableModels: models,
+	}
+}
+
+func TestGetChatCompletionsDeployments(t *testing.T) {
+	paths := make(chan string, 2)
+	handler := testHandler(http.StatusOK)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		paths <- req.URL.Path
+		handler.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+	serverConfigs := []server.ServerConfig{}
+	for _, deployment := range []string{"gpt4o-eastus", "gpt-4o-prod"} {
+		serverConfigs = append(serverConfigs, server.ServerConfig{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        ts.URL,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			Deployments:     map[string]string{"gpt-4o": deployment},
+		})
+	}
+	router, err := NewRouter(serverConfigs, RoundRobinStrategy)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+			Model: openai.F(openai.ChatModelGPT4o),
+		}); err != nil {
+			t.Fatalf("Error was not expected %v", err)
+		}
+	}
+	got := []string{<-paths, <-paths}
+	slices.Sort(got)
+	expected := []string{"/openai/deployments/gpt-4o-prod/chat/completions", "/openai/deployments/gpt4o-eastus/chat/completions"}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("Requests were not sent to the mapped deployments %v", got)
+	}
+}
+
+func TestGetCompletions(t *testing.T) {
+	failing, failingCount := newCountingTestServer(t, http.StatusServiceUnavailable)
+	paths := make(chan string, 1)
+	handler := testHandler(http.StatusOK)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		paths <- req.URL.Path
+		handler.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+	serverConfigs := []server.ServerConfig{}
+	for _, endpoint := range []string{failing.URL, ts.URL} {
+		serverConfigs = append(serverConfigs, server.ServerConfig{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        endpoint,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			Deployments:     map[string]string{"gpt-3.5-turbo-instruct": "gpt-35-turbo-instruct"},
+		})
+	}
+	router, err := NewRouter(serverConfigs, RoundRobinStrategy)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	completion, err := router.GetCompletions(context.TODO(), openai.CompletionNewParams{
+		Model:  openai.F(openai.CompletionNewParamsModelGPT3_5TurboInstruct),
+		Prompt: openai.F[openai.CompletionNewParamsPromptUnion](shared.UnionString("Who wrote the Jungle Book?")),
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if failingCount.Load() != 1 || len(completion.Choices) != 1 || completion.Choices[0].Text != "Kipling" {
+		t.Fatalf("Incorrect completion %+v", completion)
+	}
+	if path := <-paths; path != "/openai/deployments/gpt-35-turbo-instruct/completions" {
+		t.Fatalf("The request was not sent to the mapped deployment %s", path)
+	}
+}
+
+func TestGetEmbeddings(t *testing.T) {
+	failing, failingCount := newCountingTestServer(t, http.StatusServiceUnavailable)
+	paths := make(chan string, 1)
+	handler := testHandler(http.StatusOK)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		paths <- req.URL.Path
+		handler.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+	serverConfigs := []server.ServerConfig{}
+	for _, endpoint := range []string{failing.URL, ts.URL} {
+		serverConfigs = append(serverConfigs, server.ServerConfig{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        endpoint,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			Deployments:     map[string]string{"text-embedding-3-small": "embeddings-eastus"},
+		})
+	}
+	router, err := NewRouter(serverConfigs, RoundRobinStrategy)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	info := &RouteInfo{}
+	embeddings, err := router.GetEmbeddings(ContextWithRouteInfo(context.TODO(), info), openai.EmbeddingNewParams{
+		Input: openai.F[openai.EmbeddingNewParamsInputUnion](shared.UnionString("hello")),
+		Model: openai.F(openai.EmbeddingModelTextEmbedding3Small),
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if len(embeddings.Data) != 1 || len(embeddings.Data[0].Embedding) != 2 {
+		t.Fatalf("Incorrect embeddings %+v", embeddings.Data)
+	}
+	if failingCount.Load() != 1 || info.Server != ts.URL || len(info.Attempts) != 1 {
+		t.Fatalf("The request should have failed over to the healthy server, got %+v", info)
+	}
+	if path := <-paths; path != "/openai/deployments/embeddings-eastus/embeddings" {
+		t.Fatalf("The request was not sent to the mapped deployment %s", path)
+	}
+	if latency, ok := router.servers[1].ModelLatency("text-embedding-3-small"); !ok || latency.Samples != 1 {
+		t.Fatalf("The latency of the embeddings model should be recorded, got %+v", latency)
+	}
+}
+
+func TestGetAudioTranscriptionReplaysFile(t *testing.T) {
+	failing, failingCount := newCountingTestServer(t, http.StatusServiceUnavailable)
+	var filename, content string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		file, header, err := req.FormFile("file")
+		if err != nil {
+			t.Errorf("Error was not expected %v", err)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		filename, content = header.Filename, string(data)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text":"Hello"}`))
+	}))
+	defer ts.Close()
+	router := getRouterForModels(t, RoundRobinStrategy, []string{"whisper-1"}, []string{failing.URL, ts.URL})
+	transcription, err := router.GetAudioTranscription(context.TODO(), openai.AudioTranscriptionNewParams{
+		File:  openai.FileParam(strings.NewReader("RIFF audio"), "hello.wav", "audio/wav"),
+		Model: openai.F(openai.AudioModelWhisper1),
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if failingCount.Load() != 1 || transcription.Text != "Hello" {
+		t.Fatalf("The request should have failed over, got %+v", transcription)
+	}
+	if filename != "hello.wav" || content != "RIFF audio" {
+		t.Fatalf("The whole file should be sent again to the next server, got %s %q", filename, content)
+	}
+}
+
+func TestGetAudioTranslation(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text":"Hello"}`))
+	}))
+	defer ts.Close()
+	router := getRouterForModels(t, RoundRobinStrategy, []string{"whisper-1"}, []string{ts.URL})
+	translation, err := router.GetAudioTranslation(context.TODO(), openai.AudioTranslationNewParams{
+		File:  openai.F[io.Reader](strings.NewReader("RIFF audio")),
+		Model: openai.F(openai.AudioModelWhisper1),
+	})
+	if err != nil || translation.Text != "Hello" {
+		t.Fatalf("Incorrect translation %+v, error %v", translation, err)
+	}
+}
+
+func TestGenerateImagesAndSpeech(t *testing.T) {
+	ts := newTestServer(t, http.StatusOK)
+	router, err := NewRouter([]server.ServerConfig{{
+		Type:            server.AzureOpenAiServerType,
+		Endpoint:        ts.URL,
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		AvailableModels: []string{"dall-e-3", "tts-1"},
+	}}, RoundRobinStrategy)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	images, err := router.GenerateImages(context.TODO(), openai.ImageGenerateParams{
+		Prompt: openai.F("A tiger in the jungle"),
+		Model:  openai.F(openai.ImageModelDallE3),
+	})
+	if err != nil || len(images.Data) != 1 {
+		t.Fatalf("Incorrect images %+v, error %v", images, err)
+	}
+	speech, err := router.GetSpeech(context.TODO(), openai.AudioSpeechNewParams{
+		Input: openai.F("Hello"),
+		Model: openai.F(openai.SpeechModelTTS1),
+		Voice: openai.F(openai.AudioSpeechNewParamsVoiceAlloy),
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer speech.Body.Close()
+	if audio, _ := io.ReadAll(speech.Body); string(audio) != "audio" {
+		t.Fatalf("Incorrect speech %q", audio)
+	}
+	if _, err := router.GenerateImages(context.TODO(), openai.ImageGenerateParams{Prompt: openai.F("A tiger")}); !errors.Is(err, ErrNoServerAvailable) {
+		t.Fatalf("Images without a model should not be routed, got %v", err)
+	}
+}
+
+func TestCreateResponsePinned(t *testing.T) {
+	first, firstCount := newCountingTestServer(t, http.StatusOK)
+	second, secondCount := newCountingTestServer(t, http.StatusOK)
+	router := getRouterForEndpoints(t, RoundRobinStrategy, first.URL, second.URL)
+	response, err := router.CreateResponse(context.TODO(), server.ResponseNewParams{Model: "gpt-3.5-turbo", Input: "Hi"})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := router.CreateResponse(context.TODO(), server.ResponseNewParams{
+			Model:              "gpt-3.5-turbo",
+			Input:              "And then?",
+			PreviousResponseID: response.ID,
+		}); err != nil {
+			t.Fatalf("Error was not expected %v", err)
+		}
+	}
+	if firstCount.Load() != 4 || secondCount.Load() != 0 {
+		t.Fatalf("Requests continuing a response should be sent to the server that created it, got %d and %d", firstCount.Load(), secondCount.Load())
+	}
+
+	// The other server does not know the response, so the request is not failed over.
+	first.Config.Handler = testHandler(http.StatusServiceUnavailable)
+	_, err = router.CreateResponse(context.TODO(), server.ResponseNewParams{
+		Model:              "gpt-3.5-turbo",
+		Input:              "And then?",
+		PreviousResponseID: response.ID,
+	})
+	var failoverErr *FailoverError
+	if !errors.As(err, &failoverErr) || len(failoverErr.Attempts) != 1 || secondCount.Load() != 0 {
+		t.Fatalf("A pinned request should not be failed over, got %v", err)
+	}
+}
+
+func TestCreateResponseStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_1\"}}\n\n"))
+	}))
+	defer ts.Close()
+	router := getRouterForEndpoints(t, RoundRobinStrategy, newTestServer(t, http.StatusServiceUnavailable).URL, ts.URL)
+	stream, err := router.CreateResponseStream(context.TODO(), server.ResponseNewParams{Model: "gpt-3.5-turbo", Input: "Hi"})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer stream.Close()
+	if !stream.Next() || stream.Current().Type != "response.created" {
+		t.Fatalf("Incorrect stream event %+v, error %v", stream.Current(), stream.Err())
+	}
+}
+
+func TestGetChatCompletionsFallbacks(t *testing.T) {
+	throttled := newTestServer(t, http.StatusTooManyRequests)
+	healthy := newTestServer(t, http.StatusOK)
+	router, err := NewRouter([]server.ServerConfig{
+		{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        throttled.URL,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			AvailableModels: []string{"gpt-4o"},
+		},
+		{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        healthy.URL,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			AvailableModels: []string{"gpt-35-turbo"},
+		},
+	}, RoundRobinStrategy, WithFallbacks(map[string][]string{
+		"gpt-4o": {"gpt-4o-mini", "gpt-35-turbo"},
+	}))
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+
+	info := RouteInfo{}
+	ctx := ContextWithRouteInfo(context.TODO(), &info)
+	_, err = router.GetChatCompletions(ctx, openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT4o),
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if info.Model != "gpt-35-turbo" || info.Server != healthy.URL {
+		t.Fatalf("Incorrect route info %+v", info)
+	}
+	if len(info.Attempts) != 1 || info.Attempts[0].Model != "gpt-4o" {
+		t.Fatalf("Incorrect attempts %+v", info.Attempts)
+	}
+
+	stream, err := router.GetChatCompletionsStream(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT4o),
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	stream.Close()
+}
+
+func TestGetChatCompletionsNoFallbackOnClientError(t *testing.T) {
+	invalid := newTestServer(t, http.StatusBadRequest)
+	healthy := newTestServer(t, http.StatusOK)
+	router, err := NewRouter([]server.ServerConfig{
+		{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        invalid.URL,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			AvailableModels: []string{"gpt-4o"},
+		},
+		{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        healthy.URL,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			AvailableModels: []string{"gpt-4o-mini"},
+		},
+	}, RoundRobinStrategy, WithFallbacks(map[string][]string{"gpt-4o": {"gpt-4o-mini"}}))
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	_, err = router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT4o),
+	})
+	var failoverErr *FailoverError
+	if !errors.As(err, &failoverErr) || failoverErr.Model != "gpt-4o" {
+		t.Fatalf("A client error should not fall back to another model, got %v", err)
+	}
+}
+
+func TestGetChatCompletionsFallbacksExhausted(t *testing.T) {
+	router := getRouter()
+	WithFallbacks(map[string][]string{"gpt-4o": {"gpt-4o-mini"}})(router)
+	_, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT4o),
+	})
+	if !errors.Is(err, ErrNoServerAvailable) || !strings.Contains(err.Error(), "gpt-4o-mini") {
+		t.Fatalf("Expected ErrNoServerAvailable for the last fallback model but got %v", err)
+	}
+
+	router.SetFallbacks(nil)
+	_, err = router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT4o),
+	})
+	if !errors.Is(err, ErrNoServerAvailable) || strings.Contains(err.Error(), "gpt-4o-mini") {
+		t.Fatalf("The removed fallbacks should not be used, got %v", err)
+	}
+}
+
+func TestConcurrentChatCompletions(t *testing.T) {
+	first := newTestServer(t, http.StatusOK)
+	second := newTestServer(t, http.StatusOK)
+	third := newTestServer(t, http.StatusServiceUnavailable)
+	for _, strategyType := range []RouterStrategyType{RoundRobinStrategy, LeastConnectionStrategy, LeastLatencyStrategy} {
+		router := getRouterForEndpoints(t, strategyType, first.URL, second.URL, third.URL)
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				body := openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT3_5Turbo)}
+				if i%2 == 0 {
+					router.GetChatCompletions(context.TODO(), body)
+					return
+				}
+				stream, err := router.GetChatCompletionsStream(context.TODO(), body)
+				if err == nil {
+					for stream.Next() {
+					}
+					stream.Close()
+				}
+			}()
+		}
+		wg.Wait()
+		if router.requestCount.Load() != 50 {
+			t.Fatalf("%s: incorrect requests count %d", strategyType, router.requestCount.Load())
+		}
+		for _, s := range router.servers {
+			if s.ActiveConnections.Load() != 0 {
+				t.Fatalf("%s: incorrect active connections %d", strategyType, s.ActiveConnections.Load())
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/acai-travel/go-openai-router/v2/pkg/server"
+)
+
+// serverList returns the servers of the router. The returned slice must not be modified.
+func (r *Router) serverList() []*server.RouterServer {
+	r.serversMu.RLock()
+	defer r.serversMu.RUnlock()
+	return r.servers
+}
+
+// Servers returns the names of the servers of the router, draining servers included.
+func (r *Router) Servers() []string {
+	servers := r.serverList()
+	names := make([]string, 0, len(servers))
+	for _, s := range servers {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// AddServer creates a server from serverConfig and adds it to the router. It receives requests as soon as it is
+// added. The name of the server, its Endpoint when Name is empty, must not be used by another server of the router.
+func (r *Router) AddServer(serverConfig server.ServerConfig) error {
+	return r.UpdateServers(nil, []server.ServerConfig{serverConfig})
+}
+
+// DrainServer stops sending new requests to the server with the given name and waits until its in-flight
+// requests, streams included, are finished or ctx is done. The server stays part of the router until it is removed
+// with RemoveServer, but it cannot be undrained.
+func (r *Router) DrainServer(ctx context.Context, name string) error {
+	s := r.server(name)
+	if s == nil {
+		return fmt.Errorf("%w: %s", ErrServerNotFound, name)
+	}
+	return s.Drain(ctx)
+}
+
+// RemoveServer removes the server with the given name from the router and stops its background work. Its in-flight
+// requests are not interrupted, drain it with DrainServer first to wait for them.
+func (r *Router) RemoveServer(name string) error {
+	return r.UpdateServers([]string{name}, nil)
+}
+
+// UpdateServers removes the servers with the given names and adds servers created from serverConfigs in a single
+// step, so that requests see either the previous servers or the updated ones. A server can be replaced by removing
+// it and adding a server with the same name. Nothing is changed when one of the servers cannot be created or
+// removed. The removed servers are handled like in RemoveServer.
+func (r *Router) UpdateServers(remove []string, serverConfigs []server.ServerConfig) error {
+	added, err := newServers(serverConfigs)
+	if err != nil {
+		return err
+	}
+	r.serversMu.Lock()
+	servers := slices.Clone(r.servers)
+	removed := []*server.RouterServer{}
+	errs := []error{}
+	for _, name := range remove {
+		index := slices.IndexFunc(servers, func(s *server.RouterServer) bool { return s.Name == name })
+		if index < 0 {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrServerNotFound, name))
+			continue
+		}
+		removed = append(removed, servers[index])
+		servers = slices.Delete(servers, index, index+1)
+	}
+	for _, s := range added {
+		if slices.ContainsFunc(servers, func(existing *server.RouterServer) bool { return existing.Name == s.Name }) {
+			errs = append(errs, fmt.Errorf("server %s already exists", s.Name))
+			continue
+		}
+		servers = append(servers, s)
+	}
+	if len(errs) > 0 {
+		r.serversMu.Unlock()
+		closeServers(added)
+		return errors.Join(errs...)
+	}
+	r.servers = servers
+	r.serverCount = len(servers)
+	r.serversMu.Unlock()
+	if remover, ok := r.strategy.(serverRemover); ok {
+		for _, s := range removed {
+			remover.removeServer(s)
+		}
+	}
+	closeServers(removed)
+	return nil
+}
+
+// newServers creates the servers of serverConfigs. When one of them cannot be created, the servers created
+// before it are closed.
+func newServers(serverConfigs []server.ServerConfig) ([]*server.RouterServer, error) {
+	servers := make([]*server.RouterServer, 0, len(serverConfigs))
+	for _, serverConfig := range serverConfigs {
+		s, err := server.NewRouterServer(serverConfig)
+		if err != nil {
+			closeServers(servers)
+			return nil, fmt.Errorf("server %s: %w", cmp.Or(serverConfig.Name, serverConfig.Endpoint), err)
+		}
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
+
+// closeServers stops the background work of servers.
+func closeServers(servers []*server.RouterServer) {
+	for _, s := range servers {
+		s.Close()
+	}
+}
+
+// server returns the server with the given name, or nil if there is none.
+func (r *Router) server(name string) *server.RouterServer {
+	for _, s := range r.serverList() {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
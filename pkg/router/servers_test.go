@@ -0,0 +1,212 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/acai-travel/go-openai-router/v2/pkg/server"
+	"github.com/openai/openai-go"
+)
+
+func TestAddDrainRemoveServer(t *testing.T) {
+	old, oldCount := newCountingTestServer(t, http.StatusOK)
+	migrated, migratedCount := newCountingTestServer(t, http.StatusOK)
+	router := getRouterForEndpoints(t, WeightedRoundRobinStrategy, old.URL)
+	body := openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT3_5Turbo)}
+
+	if err := router.AddServer(getTestServerConfig(old.URL, "gpt-3.5-turbo")); err == nil {
+		t.Fatal("Error was expected for a server that already exists")
+	}
+	if err := router.AddServer(getTestServerConfig(migrated.URL, "gpt-3.5-turbo")); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if !slices.Equal(router.Servers(), []string{old.URL, migrated.URL}) {
+		t.Fatalf("Incorrect servers %v", router.Servers())
+	}
+
+	stream, err := router.GetChatCompletionsStream(context.TODO(), body)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	drained := make(chan error)
+	go func() {
+		drained <- router.DrainServer(context.TODO(), old.URL)
+	}()
+	select {
+	case err := <-drained:
+		t.Fatalf("The server should not be drained while its stream is open, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	for range 4 {
+		if _, err := router.GetChatCompletions(context.TODO(), body); err != nil {
+			t.Fatalf("Error was not expected %v", err)
+		}
+	}
+	if oldCount.Load() != 1 || migratedCount.Load() != 4 {
+		t.Fatalf("Incorrect number of requests %d %d", oldCount.Load(), migratedCount.Load())
+	}
+	for stream.Next() {
+	}
+	stream.Close()
+	if err := <-drained; err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+
+	if err := router.RemoveServer(old.URL); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if !slices.Equal(router.Servers(), []string{migrated.URL}) {
+		t.Fatalf("Incorrect servers %v", router.Servers())
+	}
+	if err := router.RemoveServer(old.URL); !errors.Is(err, ErrServerNotFound) {
+		t.Fatalf("Incorrect error %v", err)
+	}
+	if err := router.DrainServer(context.TODO(), old.URL); !errors.Is(err, ErrServerNotFound) {
+		t.Fatalf("Incorrect error %v", err)
+	}
+	strategy := router.strategy.(*weightedRoundRobinServerStrategy)
+	for _, currentWeights := range strategy.currentWeights {
+		for s := range currentWeights {
+			if s.Name == old.URL {
+				t.Fatal("The strategy should forget the removed server")
+			}
+		}
+	}
+}
+
+func TestUpdateServersAtomic(t *testing.T) {
+	first := newTestServer(t, http.StatusOK)
+	second := newTestServer(t, http.StatusOK)
+	router := getRouterForEndpoints(t, RoundRobinStrategy, first.URL)
+	invalid := getTestServerConfig(second.URL, "gpt-3.5-turbo")
+	invalid.AzureAPIVersion = ""
+	err := router.UpdateServers([]string{first.URL}, []server.ServerConfig{getTestServerConfig(first.URL, "gpt-3.5-turbo"), invalid})
+	if err == nil || !strings.Contains(err.Error(), second.URL) {
+		t.Fatalf("The error should name the invalid server, got %v", err)
+	}
+	if err := router.UpdateServers([]string{first.URL, "unknown"}, []server.ServerConfig{getTestServerConfig(second.URL, "gpt-3.5-turbo")}); !errors.Is(err, ErrServerNotFound) {
+		t.Fatalf("Incorrect error %v", err)
+	}
+	if !slices.Equal(router.Servers(), []string{first.URL}) {
+		t.Fatalf("A failed update should not change the servers, got %v", router.Servers())
+	}
+	if err := router.UpdateServers([]string{first.URL}, []server.ServerConfig{getTestServerConfig(first.URL, "gpt-3.5-turbo"), getTestServerConfig(second.URL, "gpt-3.5-turbo")}); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if !slices.Equal(router.Servers(), []string{first.URL, second.URL}) {
+		t.Fatalf("Incorrect servers %v", router.Servers())
+	}
+}
+
+func TestDrainServerContextDone(t *testing.T) {
+	ts := newTestServer(t, http.StatusOK)
+	router := getRouterForEndpoints(t, RoundRobinStrategy, ts.URL)
+	stream, err := router.GetChatCompletionsStream(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT3_5Turbo)})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer stream.Close()
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	if err := router.DrainServer(ctx, ts.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Incorrect error %v", err)
+	}
+	if _, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT3_5Turbo)}); !errors.Is(err, ErrNoServerAvailable) {
+		t.Fatalf("A draining server should not receive requests, got %v", err)
+	}
+}
+
+func TestDrainServerConcurrentRequests(t *testing.T) {
+	drained, drainedCount := newCountingTestServer(t, http.StatusOK)
+	other, otherCount := newCountingTestServer(t, http.StatusOK)
+	router := getRouterForEndpoints(t, RoundRobinStrategy, drained.URL, other.URL)
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT3_5Turbo)})
+			errs <- err
+		}()
+	}
+	if err := router.DrainServer(context.TODO(), drained.URL); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	count := drainedCount.Load()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("The requests refused by the draining server should fail over, got %v", err)
+		}
+	}
+	if drainedCount.Load() != count {
+		t.Fatalf("The drained server received %d requests after its drain", drainedCount.Load()-count)
+	}
+	if drainedCount.Load()+otherCount.Load() != 100 {
+		t.Fatalf("Incorrect number of requests %d %d", drainedCount.Load(), otherCount.Load())
+	}
+}
+
+// drainingStrategy selects the first candidate and drains the first server it selects, as if DrainServer was
+// called right after the selection.
+type drainingStrategy struct {
+	router *Router
+	once   sync.Once
+}
+
+func (s *drainingStrategy) SelectServer(ctx context.Context, req Request, candidates []ServerSnapshot) int {
+	s.once.Do(func() { s.router.DrainServer(ctx, candidates[0].Name) })
+	return 0
+}
+
+func TestDrainServerAfterSelection(t *testing.T) {
+	drained, drainedCount := newCountingTestServer(t, http.StatusOK)
+	other, otherCount := newCountingTestServer(t, http.StatusOK)
+	strategy := &drainingStrategy{}
+	router, err := NewRouterWithStrategy([]server.ServerConfig{getTestServerConfig(drained.URL, "gpt-3.5-turbo"), getTestServerConfig(other.URL, "gpt-3.5-turbo")}, strategy)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	strategy.router = router
+	if _, err := router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT3_5Turbo)}); err != nil {
+		t.Fatalf("The request refused by the draining server should fail over, got %v", err)
+	}
+	if drainedCount.Load() != 0 || otherCount.Load() != 1 {
+		t.Fatalf("Incorrect number of requests %d %d", drainedCount.Load(), otherCount.Load())
+	}
+}
+
+func TestConcurrentAddRemoveServers(t *testing.T) {
+	endpoints := []string{newTestServer(t, http.StatusOK).URL, newTestServer(t, http.StatusOK).URL, newTestServer(t, http.StatusOK).URL}
+	router := getRouterForEndpoints(t, WeightedRoundRobinStrategy, endpoints[0])
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			router.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT3_5Turbo)})
+		}()
+	}
+	for _, endpoint := range endpoints[1:] {
+		if err := router.AddServer(getTestServerConfig(endpoint, "gpt-3.5-turbo")); err != nil {
+			t.Fatalf("Error was not expected %v", err)
+		}
+	}
+	for _, endpoint := range endpoints[:2] {
+		if err := router.RemoveServer(endpoint); err != nil {
+			t.Fatalf("Error was not expected %v", err)
+		}
+	}
+	wg.Wait()
+	if !slices.Equal(router.Servers(), endpoints[2:]) {
+		t.Fatalf("Incorrect servers %v", router.Servers())
+	}
+}
@@ -1,8 +1,16 @@
 package router
 
 import (
+	"cmp"
+	"context"
+	"fmt"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
 	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/acai-travel/go-openai-router/v2/pkg/server"
 )
@@ -16,91 +24,410 @@ const (
 	LeastConnectionStrategy RouterStrategyType = "least-connection"
 	//Least Average Strategy to get a server
 	LeastLatencyStrategy RouterStrategyType = "least-latency"
+	//Usage Based Strategy to get the server with the most tokens/requests per minute headroom
+	UsageBasedStrategy RouterStrategyType = "usage-based"
+	//Smooth Weighted Round Robin Strategy to get a server in proportion to its weight
+	WeightedRoundRobinStrategy RouterStrategyType = "weighted-round-robin"
+	//Least Time To First Token Strategy to get the server that starts streaming the model the fastest
+	LeastTTFTStrategy RouterStrategyType = "least-ttft"
+	//Throughput Strategy to get the server that generates the tokens of the model the fastest, whatever the length of the completions
+	ThroughputStrategy RouterStrategyType = "throughput"
+	//Power Of Two Choices Strategy to get the least busy of two random servers, which avoids herding when many routers share the same servers
+	PowerOfTwoChoicesStrategy RouterStrategyType = "power-of-two-choices"
 )
 
-type routerStrategy interface {
-	GetAvailableServer(router *Router, modelName string) *server.RouterServer
+// PowerOfTwoChoicesScore is what the power of two choices strategy compares the two sampled servers on.
+type PowerOfTwoChoicesScore string
+
+const (
+	//Active connections of the server, the fewest wins
+	ConnectionsScore PowerOfTwoChoicesScore = "connections"
+	//Recent latency of the model on the server, the lowest wins
+	LatencyScore PowerOfTwoChoicesScore = "latency"
+	//Tokens/requests per minute headroom of the model on the server, the most wins
+	HeadroomScore PowerOfTwoChoicesScore = "headroom"
+)
+
+// Request describes the request a Strategy selects a server for.
+type Request struct {
+	Model  string // Model is the logical model name of the request.
+	Params any    // Params is the body of the request, for example an openai.ChatCompletionNewParams or an openai.EmbeddingNewParams.
+	pinned *server.RouterServer
 }
 
-func newRouterStrategy(strategyType RouterStrategyType) routerStrategy {
-	switch strategyType {
-	case RoundRobinStrategy:
-		return &simpleRoundRobinRouterStrategy{}
-	case LeastConnectionStrategy:
-		return &leastConnectionServerStrategy{}
-	case LeastLatencyStrategy:
-		return &leastLatencyServerStrategy{}
-	default:
-		return &simpleRoundRobinRouterStrategy{}
+// ServerSnapshot is a read-only view of a candidate server and of its stats for the requested model,
+// taken when the request is routed.
+type ServerSnapshot struct {
+	Name              string
+	Endpoint          string
+	Type              server.ServerConfigType
+	Labels            map[string]string
+	Weight            int
+	ActiveConnections int64
+	Latency           int64             // Latency is the recent average latency of the model in milliseconds, of the server if it never served the model.
+	TimeToFirstToken  time.Duration     // TimeToFirstToken is the recent time to first token of the model, zero if it was never streamed.
+	Throughput        server.Throughput // Throughput is the recent token throughput of the model, zero if no usage was reported yet.
+	Headroom          float64
+	CircuitState      server.CircuitState
+	server            *server.RouterServer
+	model             string
+}
+
+// LatencyStats returns the recent latency percentiles of the model, zero if it was never served. Unlike the
+// other stats of the snapshot they are computed when called, only for the strategies that use them.
+func (c ServerSnapshot) LatencyStats() server.LatencyStats {
+	if c.server == nil {
+		return server.LatencyStats{}
 	}
+	stats, _ := c.server.ModelLatency(c.model)
+	return stats
 }
 
-type simpleRoundRobinRouterStrategy struct{}
+func newServerSnapshot(s *server.RouterServer, modelName string) ServerSnapshot {
+	timeToFirstToken, _ := s.ModelTimeToFirstToken(modelName)
+	throughput, _ := s.ModelThroughput(modelName)
+	average, ok := s.ModelAverageLatency(modelName)
+	latency := average.Milliseconds()
+	if !ok {
+		latency = s.Latency.Load()
+	}
+	return ServerSnapshot{
+		Name:              s.Name,
+		Endpoint:          s.Endpoint,
+		Type:              s.Type,
+		Labels:            maps.Clone(s.Labels),
+		Weight:            s.Weight,
+		ActiveConnections: s.ActiveConnections.Load(),
+		Latency:           latency,
+		TimeToFirstToken:  timeToFirstToken,
+		Throughput:        throughput,
+		Headroom:          s.Headroom(modelName),
+		CircuitState:      s.CircuitState(),
+		server:            s,
+		model:             modelName,
+	}
+}
 
-// GetAvailableServer returns an available server from the router based on the provided model name.
-// It filters the servers based on the available models and uses a simple round-robin strategy to select a server.
-// If no server is available for the given model, it returns nil.
-func (s *simpleRoundRobinRouterStrategy) GetAvailableServer(r *Router, modelName string) *server.RouterServer {
-	filteredServers := []*server.RouterServer{}
-	for _, server := range r.servers {
-		if slices.Contains(server.AvailableModels, modelName) {
+// Strategy selects the server a request is sent to.
+// The router only passes the candidates that are available for the requested model, so a Strategy only
+// decides between healthy servers. Implementations must be safe for concurrent use.
+type Strategy interface {
+	// SelectServer returns the index in candidates of the server req is sent to, or -1 to send it nowhere.
+	// candidates is never empty.
+	SelectServer(ctx context.Context, req Request, candidates []ServerSnapshot) int
+}
+
+// serverRemover is implemented by the strategies that keep state per server, to forget the servers removed
+// from the router.
+type serverRemover interface {
+	removeServer(s *server.RouterServer)
+}
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = map[RouterStrategyType]func() Strategy{
+		RoundRobinStrategy:         func() Strategy { return &simpleRoundRobinRouterStrategy{} },
+		LeastConnectionStrategy:    func() Strategy { return &leastConnectionServerStrategy{} },
+		LeastLatencyStrategy:       func() Strategy { return &leastLatencyServerStrategy{} },
+		UsageBasedStrategy:         func() Strategy { return &usageBasedServerStrategy{} },
+		WeightedRoundRobinStrategy: func() Strategy { return newWeightedRoundRobinServerStrategy() },
+		LeastTTFTStrategy:          func() Strategy { return &leastTTFTServerStrategy{} },
+		ThroughputStrategy:         func() Strategy { return &throughputServerStrategy{} },
+		PowerOfTwoChoicesStrategy:  func() Strategy { return NewPowerOfTwoChoicesStrategy(ConnectionsScore, rand.Uint64()) },
+	}
+)
+
+// RegisterStrategy makes a custom strategy available under strategyType, so it can be used with NewRouter
+// and referenced by name from configuration. The factory is called once per router.
+// Registering a strategyType that already exists replaces it.
+func RegisterStrategy(strategyType RouterStrategyType, factory func() Strategy) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	strategies[strategyType] = factory
+}
+
+// NewStrategy returns a new instance of the strategy registered under strategyType.
+func NewStrategy(strategyType RouterStrategyType) (Strategy, error) {
+	strategiesMu.RLock()
+	factory, ok := strategies[strategyType]
+	strategiesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %s", strategyType)
+	}
+	return factory(), nil
+}
+
+func newRouterStrategy(strategyType RouterStrategyType) Strategy {
+	strategy, err := NewStrategy(strategyType)
+	if err != nil {
+		slog.Warn("Unknown Strategy, using Round Robin", "strategy", strategyType)
+		return &simpleRoundRobinRouterStrategy{}
+	}
+	return strategy
+}
+
+// filterServers returns the servers that are available for modelName, skipping the excluded servers.
+// Servers whose circuit breaker is open or that ran out of quota for the model are not available.
+func filterServers(servers []*server.RouterServer, modelName string, excluded []*server.RouterServer) []*server.RouterServer {
+	filteredServers := make([]*server.RouterServer, 0, len(servers))
+	for _, server := range servers {
+		if server.IsAvailable(modelName) && !slices.Contains(excluded, server) {
 			filteredServers = append(filteredServers, server)
 		}
 	}
-	if len(filteredServers) == 0 {
-		return nil
-	}
-	serverIndex := r.requestCount % len(filteredServers)
+	return filteredServers
+}
+
+type simpleRoundRobinRouterStrategy struct {
+	requestCount atomic.Uint64
+}
+
+// SelectServer selects the candidates one after the other.
+func (s *simpleRoundRobinRouterStrategy) SelectServer(ctx context.Context, req Request, candidates []ServerSnapshot) int {
+	serverIndex := int((s.requestCount.Add(1) - 1) % uint64(len(candidates)))
 	slog.Debug("Simple Round Robin Server", "serverIndex", serverIndex)
-	return filteredServers[serverIndex]
+	return serverIndex
 }
 
 type leastConnectionServerStrategy struct{}
 
-// GetAvailableServer returns the server with the least active connections that supports the specified model.
-// If no server is available for the model, it returns nil.
-func (s *leastConnectionServerStrategy) GetAvailableServer(r *Router, modelName string) *server.RouterServer {
-	filteredServers := make([]*server.RouterServer, 0)
-	for _, server := range r.servers {
-		if slices.Contains(server.AvailableModels, modelName) {
-			filteredServers = append(filteredServers, server)
+// SelectServer selects the candidate with the least active connections.
+func (s *leastConnectionServerStrategy) SelectServer(ctx context.Context, req Request, candidates []ServerSnapshot) int {
+	minConnectionsIndex := 0
+	for i, candidate := range candidates {
+		if candidate.ActiveConnections < candidates[minConnectionsIndex].ActiveConnections {
+			minConnectionsIndex = i
 		}
 	}
+	return minConnectionsIndex
+}
+
+type leastLatencyServerStrategy struct{}
 
-	if len(filteredServers) == 0 {
-		return nil
+// SelectServer selects the candidate with the least recent latency for the model.
+func (s *leastLatencyServerStrategy) SelectServer(ctx context.Context, req Request, candidates []ServerSnapshot) int {
+	leastLatencyIndex := 0
+	for i, candidate := range candidates {
+		if candidate.Latency < candidates[leastLatencyIndex].Latency {
+			leastLatencyIndex = i
+		}
 	}
+	return leastLatencyIndex
+}
+
+type usageBasedServerStrategy struct{}
 
-	minConnectionsServer := filteredServers[0]
-	for _, server := range filteredServers {
-		if server.ActiveConnections < minConnectionsServer.ActiveConnections {
-			minConnectionsServer = server
+// SelectServer selects the candidate with the most tokens and requests per minute headroom.
+func (s *usageBasedServerStrategy) SelectServer(ctx context.Context, req Request, candidates []ServerSnapshot) int {
+	mostHeadroomIndex := 0
+	for i, candidate := range candidates {
+		if candidate.Headroom > candidates[mostHeadroomIndex].Headroom {
+			mostHeadroomIndex = i
 		}
 	}
-	return minConnectionsServer
+	return mostHeadroomIndex
 }
 
-type leastLatencyServerStrategy struct{}
+// weightedRoundRobinServerStrategy is the smooth weighted round robin used by nginx, which interleaves the servers
+// instead of sending bursts of requests to the heaviest one. The current weights are kept per model since
+// each model is served by a different set of servers.
+type weightedRoundRobinServerStrategy struct {
+	mu             sync.Mutex
+	currentWeights map[string]map[*server.RouterServer]int
+}
 
-// GetAvailableServer returns the server with the least latency that supports the specified model.
-// If no server is available for the model, it returns nil.
-func (s *leastLatencyServerStrategy) GetAvailableServer(r *Router, modelName string) *server.RouterServer {
-	filteredServers := make([]*server.RouterServer, 0)
-	for _, server := range r.servers {
-		if slices.Contains(server.AvailableModels, modelName) {
-			filteredServers = append(filteredServers, server)
+func newWeightedRoundRobinServerStrategy() *weightedRoundRobinServerStrategy {
+	return &weightedRoundRobinServerStrategy{currentWeights: map[string]map[*server.RouterServer]int{}}
+}
+
+// removeServer forgets the current weights of a server removed from the router.
+func (s *weightedRoundRobinServerStrategy) removeServer(removed *server.RouterServer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, currentWeights := range s.currentWeights {
+		delete(currentWeights, removed)
+	}
+}
+
+// SelectServer selects each candidate in proportion to its weight.
+func (s *weightedRoundRobinServerStrategy) SelectServer(ctx context.Context, req Request, candidates []ServerSnapshot) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	currentWeights, ok := s.currentWeights[req.Model]
+	if !ok {
+		currentWeights = map[*server.RouterServer]int{}
+		s.currentWeights[req.Model] = currentWeights
+	}
+	totalWeight := 0
+	selectedIndex := 0
+	for i, candidate := range candidates {
+		currentWeights[candidate.server] += candidate.Weight
+		totalWeight += candidate.Weight
+		if currentWeights[candidate.server] > currentWeights[candidates[selectedIndex].server] {
+			selectedIndex = i
 		}
 	}
+	currentWeights[candidates[selectedIndex].server] -= totalWeight
+	slog.Debug("Weighted Round Robin Server", "endpoint", candidates[selectedIndex].Endpoint, "weight", candidates[selectedIndex].Weight)
+	return selectedIndex
+}
+
+// maxExplorations is how many requests the strategies that rank the servers on a stat of the model send to a server
+// whose stat is unknown, so that it gets known. A server that keeps failing never gets the stat, and is then only
+// selected when no server has it.
+const maxExplorations = 3
 
-	if len(filteredServers) == 0 {
-		return nil
+// explorer counts, per model, the requests sent to the servers whose stat is unknown.
+type explorer struct {
+	mu       sync.Mutex
+	explored map[string]map[string]int
+	next     atomic.Uint64
+}
+
+// explore returns the index of a candidate whose stat is unknown and that was explored less than maxExplorations
+// times for modelName, or -1 if there is none. The count of a candidate is reset once its stat is known, so that it
+// is explored again when its stat expires.
+func (e *explorer) explore(modelName string, candidates []ServerSnapshot, known func(candidate ServerSnapshot) bool) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.explored == nil {
+		e.explored = map[string]map[string]int{}
+	}
+	explored, ok := e.explored[modelName]
+	if !ok {
+		explored = map[string]int{}
+		e.explored[modelName] = explored
 	}
+	selectedIndex := -1
+	for i, candidate := range candidates {
+		name := cmp.Or(candidate.Name, candidate.Endpoint)
+		if known(candidate) {
+			delete(explored, name)
+		} else if selectedIndex < 0 && explored[name] < maxExplorations {
+			explored[name]++
+			selectedIndex = i
+		}
+	}
+	return selectedIndex
+}
 
-	leastLatencyServer := filteredServers[0]
-	for _, server := range filteredServers {
-		if server.Latency < leastLatencyServer.Latency {
-			leastLatencyServer = server
+// removeServer forgets the explorations of a server removed from the router.
+func (e *explorer) removeServer(removed *server.RouterServer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, explored := range e.explored {
+		delete(explored, removed.Name)
+	}
+}
+
+// leastBusy returns the index of the candidate with the least active connections, the ties being selected one after
+// the other. It ranks the candidates when the stat of a strategy is known for none of them.
+func (e *explorer) leastBusy(candidates []ServerSnapshot) int {
+	offset := int(e.next.Add(1) % uint64(len(candidates)))
+	leastBusyIndex := offset
+	for i := range candidates {
+		index := (offset + i) % len(candidates)
+		if candidates[index].ActiveConnections < candidates[leastBusyIndex].ActiveConnections {
+			leastBusyIndex = index
 		}
 	}
-	return leastLatencyServer
+	return leastBusyIndex
+}
+
+type leastTTFTServerStrategy struct {
+	explorer
+}
+
+// SelectServer selects the candidate with the best recent time to first token for the model.
+// Candidates that never streamed the model are explored a few times so that their time to first token gets known.
+func (s *leastTTFTServerStrategy) SelectServer(ctx context.Context, req Request, candidates []ServerSnapshot) int {
+	if i := s.explore(req.Model, candidates, func(candidate ServerSnapshot) bool { return candidate.TimeToFirstToken > 0 }); i >= 0 {
+		return i
+	}
+	leastTTFTIndex := -1
+	for i, candidate := range candidates {
+		if candidate.TimeToFirstToken > 0 && (leastTTFTIndex < 0 || candidate.TimeToFirstToken < candidates[leastTTFTIndex].TimeToFirstToken) {
+			leastTTFTIndex = i
+		}
+	}
+	if leastTTFTIndex < 0 {
+		return s.leastBusy(candidates)
+	}
+	return leastTTFTIndex
+}
+
+type throughputServerStrategy struct {
+	explorer
+}
+
+// SelectServer selects the candidate with the least recent time per output token for the model, so that servers
+// are not penalized for the long completions they happened to generate.
+// Candidates whose throughput is unknown are explored a few times so that it gets known, and the least busy
+// candidate is selected when the throughput of none of them is known, for example for the streams that do not
+// report their usage.
+func (s *throughputServerStrategy) SelectServer(ctx context.Context, req Request, candidates []ServerSnapshot) int {
+	if i := s.explore(req.Model, candidates, func(candidate ServerSnapshot) bool { return candidate.Throughput.TimePerOutputToken > 0 }); i >= 0 {
+		return i
+	}
+	fastestIndex := -1
+	for i, candidate := range candidates {
+		timePerOutputToken := candidate.Throughput.TimePerOutputToken
+		if timePerOutputToken > 0 && (fastestIndex < 0 || timePerOutputToken < candidates[fastestIndex].Throughput.TimePerOutputToken) {
+			fastestIndex = i
+		}
+	}
+	if fastestIndex < 0 {
+		return s.leastBusy(candidates)
+	}
+	return fastestIndex
+}
+
+// powerOfTwoChoicesServerStrategy samples two random candidates and selects the better one. Unlike the strategies
+// that select the global minimum, routers that share a stale view of the servers do not all pile onto the same one.
+type powerOfTwoChoicesServerStrategy struct {
+	mu    sync.Mutex
+	rand  *rand.Rand
+	score func(candidate ServerSnapshot) float64
+}
+
+// NewPowerOfTwoChoicesStrategy returns a power of two choices strategy that compares the two sampled servers on
+// score. The servers are sampled from a random source seeded with seed, so that a given seed always samples the
+// same servers. The PowerOfTwoChoicesStrategy type uses the connections score and a random seed.
+func NewPowerOfTwoChoicesStrategy(score PowerOfTwoChoicesScore, seed uint64) Strategy {
+	return &powerOfTwoChoicesServerStrategy{
+		rand:  rand.New(rand.NewPCG(seed, seed)),
+		score: powerOfTwoChoicesScore(score),
+	}
+}
+
+// powerOfTwoChoicesScore returns the function that scores a candidate for score, lower scores being better.
+func powerOfTwoChoicesScore(score PowerOfTwoChoicesScore) func(candidate ServerSnapshot) float64 {
+	switch score {
+	case ConnectionsScore:
+	case LatencyScore:
+		return func(candidate ServerSnapshot) float64 { return float64(candidate.Latency) }
+	case HeadroomScore:
+		return func(candidate ServerSnapshot) float64 { return -candidate.Headroom }
+	default:
+		slog.Warn("Unknown Power Of Two Choices Score, using connections", "score", score)
+	}
+	return func(candidate ServerSnapshot) float64 { return float64(candidate.ActiveConnections) }
+}
+
+// SelectServer selects the better of two distinct random candidates.
+func (s *powerOfTwoChoicesServerStrategy) SelectServer(ctx context.Context, req Request, candidates []ServerSnapshot) int {
+	if len(candidates) == 1 {
+		return 0
+	}
+	s.mu.Lock()
+	first := s.rand.IntN(len(candidates))
+	second := s.rand.IntN(len(candidates) - 1)
+	s.mu.Unlock()
+	if second >= first {
+		second++
+	}
+	if s.score(candidates[second]) < s.score(candidates[first]) {
+		return second
+	}
+	return first
 }
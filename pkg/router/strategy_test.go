@@ -1,11 +1,18 @@
 package router
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"slices"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/acai-travel/go-openai-router/v2/pkg/server"
+	"github.com/openai/openai-go"
 )
 
 func TestNewRouterStrategy(t *testing.T) {
@@ -23,23 +30,45 @@ func TestNewRouterStrategy(t *testing.T) {
 	if reflect.TypeOf(s) != reflect.TypeOf(&leastConnectionServerStrategy{}) {
 		t.Fatalf("Incorect Strategy Object for LeastConnectionStrategy %v", reflect.TypeOf(s))
 	}
+
+	s = newRouterStrategy(UsageBasedStrategy)
+	if reflect.TypeOf(s) != reflect.TypeOf(&usageBasedServerStrategy{}) {
+		t.Fatalf("Incorect Strategy Object for UsageBasedStrategy %v", reflect.TypeOf(s))
+	}
+
+	s = newRouterStrategy(WeightedRoundRobinStrategy)
+	if reflect.TypeOf(s) != reflect.TypeOf(&weightedRoundRobinServerStrategy{}) {
+		t.Fatalf("Incorect Strategy Object for WeightedRoundRobinStrategy %v", reflect.TypeOf(s))
+	}
+
+	s = newRouterStrategy(LeastTTFTStrategy)
+	if reflect.TypeOf(s) != reflect.TypeOf(&leastTTFTServerStrategy{}) {
+		t.Fatalf("Incorect Strategy Object for LeastTTFTStrategy %v", reflect.TypeOf(s))
+	}
+
+	s = newRouterStrategy(ThroughputStrategy)
+	if reflect.TypeOf(s) != reflect.TypeOf(&throughputServerStrategy{}) {
+		t.Fatalf("Incorect Strategy Object for ThroughputStrategy %v", reflect.TypeOf(s))
+	}
+
+	s = newRouterStrategy(PowerOfTwoChoicesStrategy)
+	if reflect.TypeOf(s) != reflect.TypeOf(&powerOfTwoChoicesServerStrategy{}) {
+		t.Fatalf("Incorect Strategy Object for PowerOfTwoChoicesStrategy %v", reflect.TypeOf(s))
+	}
 }
 
 func TestRoundRobinStrategy(t *testing.T) {
 	strategy := newRouterStrategy(RoundRobinStrategy)
 	r := getRouterForRoundRobinStrategy()
-	r.requestCount = 0
-	s := strategy.GetAvailableServer(r, "gpt-3.5-turbo")
+	s := getAvailableServer(r, strategy, "gpt-3.5-turbo")
 	if s.Type != server.OpenAiServerType {
 		t.Fatalf("Incorrect server returned by Round Robin - %s", s.Type)
 	}
-	r.requestCount = 1
-	s = strategy.GetAvailableServer(r, "gpt-3.5-turbo")
+	s = getAvailableServer(r, strategy, "gpt-3.5-turbo")
 	if s.Type != server.AzureOpenAiServerType {
 		t.Fatalf("Incorrect server returned by Round Robin - %s", s.Type)
 	}
-	r.requestCount = 2
-	s = strategy.GetAvailableServer(r, "gpt-3.5-turbo")
+	s = getAvailableServer(r, strategy, "gpt-3.5-turbo")
 	if s.Type != server.OpenAiServerType {
 		t.Fatalf("Incorrect server returned by Round Robin - %s", s.Type)
 	}
@@ -48,7 +77,7 @@ func TestRoundRobinStrategy(t *testing.T) {
 func TestLeastActiveConnectionsStrategy(t *testing.T) {
 	r := getRouterForActiveConnectionsStrategy()
 	strategy := newRouterStrategy(LeastConnectionStrategy)
-	s := strategy.GetAvailableServer(r, "gpt-3.5-turbo")
+	s := getAvailableServer(r, strategy, "gpt-3.5-turbo")
 	if s.Type != server.AzureOpenAiServerType {
 		t.Fatalf("Incorrect server returned by Least Active Connection Strategy - %s", s.Type)
 	}
@@ -57,24 +86,200 @@ func TestLeastActiveConnectionsStrategy(t *testing.T) {
 func TestLeastLatencyStrategy(t *testing.T) {
 	r := getRouterForLeastLatencyStrategy()
 	strategy := newRouterStrategy(LeastLatencyStrategy)
-	s := strategy.GetAvailableServer(r, "gpt-3.5-turbo")
+	s := getAvailableServer(r, strategy, "gpt-3.5-turbo")
 	if s.Type != server.OpenAiServerType {
 		t.Fatalf("Incorrect server returned by Least Latency Strategy - %s", s.Type)
 	}
 }
 
+func TestLeastLatencyStrategyPerModel(t *testing.T) {
+	fast := newTestServer(t, http.StatusOK)
+	handler := testHandler(http.StatusOK)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		handler.ServeHTTP(w, req)
+	}))
+	t.Cleanup(slow.Close)
+	r := getRouterForEndpoints(t, RoundRobinStrategy, slow.URL, fast.URL)
+	body := openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModel("gpt-3.5-turbo"))}
+	for range r.servers {
+		if _, err := r.GetChatCompletions(context.TODO(), body); err != nil {
+			t.Fatalf("Error was not expected %v", err)
+		}
+	}
+	// The slow server looks faster overall, the latency of the model must win.
+	r.servers[0].Latency.Store(0)
+	s := getAvailableServer(r, newRouterStrategy(LeastLatencyStrategy), "gpt-3.5-turbo")
+	if s.Endpoint != fast.URL {
+		t.Fatalf("Incorrect server returned by Least Latency Strategy - %s", s.Endpoint)
+	}
+	latency, ok := r.servers[0].ModelLatency("gpt-3.5-turbo")
+	if !ok || latency.P50 < 50*time.Millisecond {
+		t.Fatalf("Incorrect model latency %+v", latency)
+	}
+	snapshot := newServerSnapshot(r.servers[0], "gpt-3.5-turbo")
+	if snapshot.LatencyStats() != latency || snapshot.Latency != latency.Average.Milliseconds() {
+		t.Fatalf("Incorrect snapshot latency %d %+v", snapshot.Latency, snapshot.LatencyStats())
+	}
+}
+
+func TestThroughputStrategy(t *testing.T) {
+	strategy := newRouterStrategy(ThroughputStrategy)
+	candidates := []ServerSnapshot{
+		{Endpoint: "https://eastus.openai.azure.com", Latency: 800, Throughput: server.Throughput{TimePerOutputToken: 40 * time.Millisecond}},
+		{Endpoint: "https://westeurope.openai.azure.com", Latency: 3000, Throughput: server.Throughput{TimePerOutputToken: 15 * time.Millisecond}},
+	}
+	if i := strategy.SelectServer(context.TODO(), Request{Model: "gpt-4o"}, candidates); i != 1 {
+		t.Fatalf("Incorrect server returned by Throughput Strategy - %s", candidates[i].Endpoint)
+	}
+
+	candidates = append(candidates, ServerSnapshot{Endpoint: "https://francecentral.openai.azure.com"})
+	for range maxExplorations {
+		if i := strategy.SelectServer(context.TODO(), Request{Model: "gpt-4o"}, candidates); i != 2 {
+			t.Fatalf("Throughput Strategy should explore the server whose throughput is unknown - %s", candidates[i].Endpoint)
+		}
+	}
+	if i := strategy.SelectServer(context.TODO(), Request{Model: "gpt-4o"}, candidates); i != 1 {
+		t.Fatalf("Throughput Strategy should stop exploring the server - %s", candidates[i].Endpoint)
+	}
+}
+
+func TestThroughputStrategyUnknown(t *testing.T) {
+	failing, failingCount := newCountingTestServer(t, http.StatusNotFound)
+	first, firstCount := newCountingTestServer(t, http.StatusOK)
+	second, secondCount := newCountingTestServer(t, http.StatusOK)
+	r := getRouterForEndpoints(t, ThroughputStrategy, failing.URL, first.URL, second.URL)
+	body := openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT3_5Turbo)}
+	// The streams do not report their usage, so the throughput of the servers is never known.
+	for range 30 {
+		stream, err := r.GetChatCompletionsStream(context.TODO(), body)
+		if err == nil {
+			for stream.Next() {
+			}
+			stream.Close()
+		}
+	}
+	for _, count := range []*atomic.Int32{failingCount, firstCount, secondCount} {
+		if count.Load() < 5 || count.Load() > 15 {
+			t.Fatalf("The requests should be spread when the throughput is unknown, got %d %d %d", failingCount.Load(), firstCount.Load(), secondCount.Load())
+		}
+	}
+}
+
+func TestPowerOfTwoChoicesStrategy(t *testing.T) {
+	strategy := NewPowerOfTwoChoicesStrategy(ConnectionsScore, 42)
+	candidates := []ServerSnapshot{
+		{Endpoint: "https://eastus.openai.azure.com", ActiveConnections: 0},
+		{Endpoint: "https://westeurope.openai.azure.com", ActiveConnections: 5},
+		{Endpoint: "https://swedencentral.openai.azure.com", ActiveConnections: 10},
+	}
+	selected := map[int]int{}
+	for range 300 {
+		selected[strategy.SelectServer(context.TODO(), Request{Model: "gpt-4o"}, candidates)]++
+	}
+	if selected[2] != 0 {
+		t.Fatalf("The busiest server should never be the better of two choices, selected %d times", selected[2])
+	}
+	if selected[0] == 0 || selected[1] == 0 {
+		t.Fatalf("Power Of Two Choices Strategy should spread the requests, got %v", selected)
+	}
+
+	if i := strategy.SelectServer(context.TODO(), Request{Model: "gpt-4o"}, candidates[2:]); i != 0 {
+		t.Fatalf("Incorrect server returned for a single candidate - %d", i)
+	}
+}
+
+func TestPowerOfTwoChoicesStrategyScores(t *testing.T) {
+	candidates := []ServerSnapshot{
+		{Endpoint: "https://eastus.openai.azure.com", ActiveConnections: 1, Latency: 900, Headroom: 0.9},
+		{Endpoint: "https://westeurope.openai.azure.com", ActiveConnections: 2, Latency: 300, Headroom: 0.1},
+	}
+	for score, expected := range map[PowerOfTwoChoicesScore]int{ConnectionsScore: 0, LatencyScore: 1, HeadroomScore: 0, "unknown": 0} {
+		strategy := NewPowerOfTwoChoicesStrategy(score, 1)
+		if i := strategy.SelectServer(context.TODO(), Request{Model: "gpt-4o"}, candidates); i != expected {
+			t.Fatalf("Incorrect server returned for the %s score - %s", score, candidates[i].Endpoint)
+		}
+	}
+}
+
+func TestPowerOfTwoChoicesStrategySeed(t *testing.T) {
+	candidates := make([]ServerSnapshot, 10)
+	first := NewPowerOfTwoChoicesStrategy(ConnectionsScore, 7)
+	second := NewPowerOfTwoChoicesStrategy(ConnectionsScore, 7)
+	for range 100 {
+		if first.SelectServer(context.TODO(), Request{}, candidates) != second.SelectServer(context.TODO(), Request{}, candidates) {
+			t.Fatal("Strategies with the same seed should select the same servers")
+		}
+	}
+}
+
+func TestLeastTTFTStrategy(t *testing.T) {
+	strategy := newRouterStrategy(LeastTTFTStrategy)
+	candidates := []ServerSnapshot{
+		{Endpoint: "https://eastus.openai.azure.com", TimeToFirstToken: 900 * time.Millisecond},
+		{Endpoint: "https://westeurope.openai.azure.com", TimeToFirstToken: 250 * time.Millisecond},
+		{Endpoint: "https://swedencentral.openai.azure.com", TimeToFirstToken: 400 * time.Millisecond},
+	}
+	if i := strategy.SelectServer(context.TODO(), Request{Model: "gpt-4o"}, candidates); i != 1 {
+		t.Fatalf("Incorrect server returned by Least TTFT Strategy - %s", candidates[i].Endpoint)
+	}
+
+	candidates = append(candidates, ServerSnapshot{Endpoint: "https://francecentral.openai.azure.com"})
+	for range maxExplorations {
+		if i := strategy.SelectServer(context.TODO(), Request{Model: "gpt-4o"}, candidates); i != 3 {
+			t.Fatalf("Least TTFT Strategy should explore the server that never streamed the model - %s", candidates[i].Endpoint)
+		}
+	}
+	// A server that fails never gets a time to first token, it should not be selected forever.
+	if i := strategy.SelectServer(context.TODO(), Request{Model: "gpt-4o"}, candidates); i != 1 {
+		t.Fatalf("Least TTFT Strategy should stop exploring the server - %s", candidates[i].Endpoint)
+	}
+	// Servers are ranked by connections when the time to first token of none of them is known.
+	unknown := []ServerSnapshot{
+		{Endpoint: "https://eastus.openai.azure.com", ActiveConnections: 4},
+		{Endpoint: "https://westeurope.openai.azure.com", ActiveConnections: 1},
+		{Endpoint: "https://swedencentral.openai.azure.com", ActiveConnections: 4},
+	}
+	for range maxExplorations * len(unknown) {
+		strategy.SelectServer(context.TODO(), Request{Model: "gpt-4o-mini"}, unknown)
+	}
+	for range len(unknown) {
+		if i := strategy.SelectServer(context.TODO(), Request{Model: "gpt-4o-mini"}, unknown); i != 1 {
+			t.Fatalf("Incorrect server returned by Least TTFT Strategy - %s", unknown[i].Endpoint)
+		}
+	}
+}
+
+func TestLeastTTFTStrategyFailingServer(t *testing.T) {
+	failing, failingCount := newCountingTestServer(t, http.StatusNotFound)
+	healthy := newTestServer(t, http.StatusOK)
+	r := getRouterForEndpoints(t, LeastTTFTStrategy, failing.URL, healthy.URL)
+	body := openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT3_5Turbo)}
+	for range 20 {
+		stream, err := r.GetChatCompletionsStream(context.TODO(), body)
+		if err == nil {
+			for stream.Next() {
+			}
+			stream.Close()
+		}
+	}
+	if failingCount.Load() > maxExplorations {
+		t.Fatalf("The failing server should only be explored, got %d requests", failingCount.Load())
+	}
+}
+
 func TestRoundRobinWithModelAvailability(t *testing.T) {
 	r := getRouterWithModelVariation()
 	strategy := newRouterStrategy(RoundRobinStrategy)
 
 	modelName := "gpt-4-vision-preview"
-	s := strategy.GetAvailableServer(r, modelName)
+	s := getAvailableServer(r, strategy, modelName)
 	if s.Type != server.OpenAiServerType || !slices.Contains(s.AvailableModels, modelName) {
 		t.Fatalf("Round Robin with Model Availability failed to select correct server for model %s", modelName)
 	}
 
 	modelName = "model-not-available"
-	s = strategy.GetAvailableServer(r, modelName)
+	s = getAvailableServer(r, strategy, modelName)
 	if s != nil {
 		t.Fatal("Round Robin with Model Availability selected a server for an unavailable model")
 	}
@@ -87,7 +292,7 @@ func TestLoadBalancingWithModelAvailability(t *testing.T) {
 
 	// Make several requests to simulate load balancing
 	for i := 0; i < 6; i++ {
-		s := strategy.GetAvailableServer(r, modelName)
+		s := getAvailableServer(r, strategy, modelName)
 		if s == nil {
 			t.Fatal("Expected to select a server but got nil")
 		}
@@ -96,7 +301,7 @@ func TestLoadBalancingWithModelAvailability(t *testing.T) {
 		}
 
 		// Increment active connections to simulate load
-		s.ActiveConnections++
+		s.ActiveConnections.Add(1)
 
 		// Ensure the third server is never selected
 		if s.Type == server.AzureOpenAiServerType {
@@ -105,13 +310,245 @@ func TestLoadBalancingWithModelAvailability(t *testing.T) {
 	}
 
 	// After load balancing, check the distribution of active connections
-	if r.servers[0].ActiveConnections == r.servers[1].ActiveConnections {
+	if r.servers[0].ActiveConnections.Load() == r.servers[1].ActiveConnections.Load() {
 		t.Log("Load balancing between servers supporting the model confirmed")
 	} else {
 		t.Fatal("Expected even distribution of load between servers supporting the model")
 	}
 }
 
+func TestStrategiesSkipServersInCooldown(t *testing.T) {
+	var throttledRequests atomic.Int32
+	throttled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		throttledRequests.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer throttled.Close()
+	healthy := newTestServer(t, http.StatusOK)
+
+	for _, strategyType := range []RouterStrategyType{RoundRobinStrategy, LeastConnectionStrategy, LeastLatencyStrategy, UsageBasedStrategy} {
+		throttledRequests.Store(0)
+		r := getRouterForEndpoints(t, strategyType, throttled.URL, healthy.URL)
+		for i := 0; i < 4; i++ {
+			_, err := r.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+				Model: openai.F(openai.ChatModelGPT3_5Turbo),
+			})
+			if err != nil {
+				t.Fatalf("%s: error was not expected %v", strategyType, err)
+			}
+		}
+		if throttledRequests.Load() > 1 {
+			t.Fatalf("%s: server in cooldown received %d requests", strategyType, throttledRequests.Load())
+		}
+	}
+}
+
+func TestUsageBasedStrategy(t *testing.T) {
+	small, smallCount := newCountingTestServer(t, http.StatusOK)
+	large, largeCount := newCountingTestServer(t, http.StatusOK)
+	serverConfigs := []server.ServerConfig{}
+	for endpoint, requestsPerMinute := range map[string]int64{small.URL: 2, large.URL: 4} {
+		serverConfigs = append(serverConfigs, server.ServerConfig{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        endpoint,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			AvailableModels: []string{"gpt-3.5-turbo"},
+			ModelLimits: map[string]server.ModelLimits{
+				"gpt-3.5-turbo": {RequestsPerMinute: requestsPerMinute},
+			},
+		})
+	}
+	r, err := NewRouter(serverConfigs, UsageBasedStrategy)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	body := openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT3_5Turbo)}
+	for i := 0; i < 6; i++ {
+		if _, err := r.GetChatCompletions(context.TODO(), body); err != nil {
+			t.Fatalf("Error was not expected %v", err)
+		}
+	}
+	if smallCount.Load() != 2 || largeCount.Load() != 4 {
+		t.Fatalf("Incorrect distribution of requests %d / %d", smallCount.Load(), largeCount.Load())
+	}
+	if _, err := r.GetChatCompletions(context.TODO(), body); !errors.Is(err, ErrNoServerAvailable) {
+		t.Fatalf("Servers that ran out of quota should be skipped, got %v", err)
+	}
+}
+
+func TestWeightedRoundRobinStrategy(t *testing.T) {
+	r := getRouterForWeightedRoundRobinStrategy()
+	strategy := newRouterStrategy(WeightedRoundRobinStrategy)
+	ptu, payg := r.servers[0], r.servers[1]
+
+	// Smooth weighted round robin interleaves the servers instead of sending bursts to the heaviest one.
+	expected := []*server.RouterServer{ptu, ptu, ptu, payg, ptu, ptu}
+	for i, e := range expected {
+		if s := getAvailableServer(r, strategy, "gpt-4o"); s != e {
+			t.Fatalf("Incorrect server returned by Weighted Round Robin for request %d - %s", i, s.Endpoint)
+		}
+	}
+
+	// gpt-35-turbo is only served by the pay as you go and the third server, which have the same weight.
+	counts := map[*server.RouterServer]int{}
+	for i := 0; i < 10; i++ {
+		s := getAvailableServer(r, strategy, "gpt-35-turbo")
+		if s == ptu {
+			t.Fatal("Weighted Round Robin selected a server that does not serve the model")
+		}
+		counts[s]++
+	}
+	if counts[payg] != 5 || counts[r.servers[2]] != 5 {
+		t.Fatalf("Incorrect distribution for gpt-35-turbo %v", counts)
+	}
+
+	// The distribution of gpt-4o is not affected by the requests for gpt-35-turbo.
+	counts = map[*server.RouterServer]int{}
+	for i := 0; i < 12; i++ {
+		counts[getAvailableServer(r, strategy, "gpt-4o")]++
+	}
+	if counts[ptu] != 10 || counts[payg] != 2 {
+		t.Fatalf("Incorrect distribution for gpt-4o %v", counts)
+	}
+
+	if getAvailableServer(r, strategy, "model-not-available") != nil {
+		t.Fatal("Weighted Round Robin selected a server for an unavailable model")
+	}
+}
+
+func getRouterForWeightedRoundRobinStrategy() *Router {
+	router, err := NewRouter([]server.ServerConfig{
+		{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        "https://ptu.openai.azure.com",
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			AvailableModels: []string{"gpt-4o"},
+			Weight:          5,
+		},
+		{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        "https://payg.openai.azure.com",
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			AvailableModels: []string{"gpt-4o", "gpt-35-turbo"},
+		},
+		{
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        "https://payg-2.openai.azure.com",
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			AvailableModels: []string{"gpt-35-turbo"},
+		},
+	}, WeightedRoundRobinStrategy)
+	if err != nil {
+		panic(err)
+	}
+	return router
+}
+
+// euFirstStrategy prefers the servers labelled with the region of the customer set in the context.
+type euFirstStrategy struct {
+	requests []Request
+}
+
+type customerRegionKey struct{}
+
+func (s *euFirstStrategy) SelectServer(ctx context.Context, req Request, candidates []ServerSnapshot) int {
+	s.requests = append(s.requests, req)
+	region, _ := ctx.Value(customerRegionKey{}).(string)
+	for i, candidate := range candidates {
+		if candidate.Labels["region"] == region {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestCustomStrategy(t *testing.T) {
+	us := newTestServer(t, http.StatusOK)
+	eu := newTestServer(t, http.StatusOK)
+	serverConfigs := []server.ServerConfig{}
+	for region, endpoint := range map[string]string{"us": us.URL, "eu": eu.URL} {
+		serverConfigs = append(serverConfigs, server.ServerConfig{
+			Name:            "azure-" + region,
+			Type:            server.AzureOpenAiServerType,
+			Endpoint:        endpoint,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			AvailableModels: []string{"gpt-4o"},
+			Labels:          map[string]string{"region": region},
+		})
+	}
+	strategy := &euFirstStrategy{}
+	r, err := NewRouterWithStrategy(serverConfigs, strategy)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+
+	info := RouteInfo{}
+	ctx := ContextWithRouteInfo(context.WithValue(context.TODO(), customerRegionKey{}, "eu"), &info)
+	body := openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT4o)}
+	if _, err := r.GetChatCompletions(ctx, body); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if info.Server != eu.URL {
+		t.Fatalf("Custom strategy was not used, request served by %s", info.Server)
+	}
+	if len(strategy.requests) != 1 || strategy.requests[0].Model != "gpt-4o" {
+		t.Fatalf("Incorrect request passed to the strategy %+v", strategy.requests)
+	}
+	if _, ok := strategy.requests[0].Params.(openai.ChatCompletionNewParams); !ok {
+		t.Fatal("The body of the request should be passed to the strategy")
+	}
+
+	ctx = context.WithValue(context.TODO(), customerRegionKey{}, "apac")
+	if _, err := r.GetChatCompletions(ctx, body); !errors.Is(err, ErrNoServerAvailable) {
+		t.Fatalf("Expected ErrNoServerAvailable when the strategy rejects every server but got %v", err)
+	}
+}
+
+func TestRegisterStrategy(t *testing.T) {
+	if _, err := NewStrategy("eu-first"); err == nil {
+		t.Fatal("Error was expected for an unknown strategy")
+	}
+	RegisterStrategy("eu-first", func() Strategy { return &euFirstStrategy{} })
+	t.Cleanup(func() {
+		strategiesMu.Lock()
+		defer strategiesMu.Unlock()
+		delete(strategies, "eu-first")
+	})
+	strategy, err := NewStrategy("eu-first")
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if reflect.TypeOf(strategy) != reflect.TypeOf(&euFirstStrategy{}) {
+		t.Fatalf("Incorect Strategy Object for a registered strategy %v", reflect.TypeOf(strategy))
+	}
+	r, err := NewRouter([]server.ServerConfig{
+		{
+			Type:            server.OpenAiServerType,
+			Endpoint:        "https://api.openai.com/v1",
+			ApiKey:          "openai-key",
+			AvailableModels: []string{"gpt-4o"},
+		},
+	}, "eu-first")
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if reflect.TypeOf(r.strategy) != reflect.TypeOf(&euFirstStrategy{}) {
+		t.Fatalf("Registered strategy was not used by the router %v", reflect.TypeOf(r.strategy))
+	}
+}
+
+// getAvailableServer returns the server strategy selects on r for a request for modelName.
+func getAvailableServer(r *Router, strategy Strategy, modelName string) *server.RouterServer {
+	r.strategy = strategy
+	return r.selectServer(context.TODO(), Request{Model: modelName}, nil)
+}
+
 func getRouterForActiveConnectionsStrategy() *Router {
 	s1, _ := server.NewRouterServer(
 		server.ServerConfig{
@@ -120,15 +557,16 @@ func getRouterForActiveConnectionsStrategy() *Router {
 			ApiKey:          "openai-key",
 			AvailableModels: []string{"gpt-3.5-turbo"},
 		})
-	s1.ActiveConnections = 10
+	s1.ActiveConnections.Store(10)
 	s2, _ := server.NewRouterServer(
 		server.ServerConfig{
 			Type:            server.AzureOpenAiServerType,
+			AzureAPIVersion: "2024-06-01",
 			Endpoint:        "https://api.openai.com",
 			ApiKey:          "openai-key",
 			AvailableModels: []string{"gpt-3.5-turbo"},
 		})
-	s2.ActiveConnections = 8
+	s2.ActiveConnections.Store(8)
 	return &Router{servers: []*server.RouterServer{s1, s2}}
 }
 
@@ -140,15 +578,16 @@ func getRouterForLeastLatencyStrategy() *Router {
 			ApiKey:          "openai-key",
 			AvailableModels: []string{"gpt-3.5-turbo"},
 		})
-	s1.Latency = 100
+	s1.Latency.Store(100)
 	s2, _ := server.NewRouterServer(
 		server.ServerConfig{
 			Type:            server.AzureOpenAiServerType,
+			AzureAPIVersion: "2024-06-01",
 			Endpoint:        "https://api.openai.com",
 			ApiKey:          "openai-key",
 			AvailableModels: []string{"gpt-3.5-turbo"},
 		})
-	s2.Latency = 6000
+	s2.Latency.Store(6000)
 	return &Router{servers: []*server.RouterServer{s1, s2}}
 }
 
@@ -162,6 +601,7 @@ func getRouterForRoundRobinStrategy() *Router {
 		},
 		{
 			Type:            server.AzureOpenAiServerType,
+			AzureAPIVersion: "2024-06-01",
 			Endpoint:        "https://azure-openai.com",
 			ApiKey:          "azure-openai-key",
 			AvailableModels: []string{"gpt-3.5-turbo", "gpt-4-turbo"},
@@ -183,6 +623,7 @@ func getRouterWithModelVariation() *Router {
 		},
 		{
 			Type:            server.AzureOpenAiServerType,
+			AzureAPIVersion: "2024-06-01",
 			Endpoint:        "https://azure-openai.com",
 			ApiKey:          "azure-openai-key",
 			AvailableModels: []string{"gpt-3.5-turbo"},
@@ -211,6 +652,7 @@ func getRouterForModelBalancingTest() *Router {
 		},
 		{
 			Type:            server.AzureOpenAiServerType,
+			AzureAPIVersion: "2024-06-01",
 			Endpoint:        "https://api.openai.com/3",
 			ApiKey:          "key3",
 			AvailableModels: []string{"gpt-3.5-turbo"},
@@ -222,3 +664,51 @@ func getRouterForModelBalancingTest() *Router {
 	}
 	return router
 }
+
+func TestStrategiesSkipOpenCircuit(t *testing.T) {
+	var failingRequests atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		failingRequests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	healthy := newTestServer(t, http.StatusOK)
+
+	for _, strategyType := range []RouterStrategyType{RoundRobinStrategy, LeastConnectionStrategy, LeastLatencyStrategy} {
+		failingRequests.Store(0)
+		r, err := NewRouter([]server.ServerConfig{
+			{
+				Type:            server.AzureOpenAiServerType,
+				Endpoint:        failing.URL,
+				AzureAPIVersion: "2024-06-01",
+				ApiKey:          "azure-openai-key",
+				AvailableModels: []string{"gpt-3.5-turbo"},
+				CircuitBreaker:  server.CircuitBreakerConfig{ConsecutiveFailures: 1, CoolDown: time.Hour},
+			},
+			{
+				Type:            server.AzureOpenAiServerType,
+				Endpoint:        healthy.URL,
+				AzureAPIVersion: "2024-06-01",
+				ApiKey:          "azure-openai-key",
+				AvailableModels: []string{"gpt-3.5-turbo"},
+			},
+		}, strategyType)
+		if err != nil {
+			t.Fatalf("Error was not expected %v", err)
+		}
+		for i := 0; i < 4; i++ {
+			_, err := r.GetChatCompletions(context.TODO(), openai.ChatCompletionNewParams{
+				Model: openai.F(openai.ChatModelGPT3_5Turbo),
+			})
+			if err != nil {
+				t.Fatalf("%s: error was not expected %v", strategyType, err)
+			}
+		}
+		if failingRequests.Load() > 1 {
+			t.Fatalf("%s: server with an open circuit received %d requests", strategyType, failingRequests.Load())
+		}
+		if r.servers[0].CircuitState() != server.CircuitOpen {
+			t.Fatalf("%s: circuit should be open, got %s", strategyType, r.servers[0].CircuitState())
+		}
+	}
+}
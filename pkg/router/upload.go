@@ -0,0 +1,41 @@
+package router
+
+import (
+	"bytes"
+	"cmp"
+	"io"
+
+	"github.com/openai/openai-go"
+)
+
+// replayableFile is a file uploaded in a multipart request, read once so that it can be sent again to
+// another server when a request is failed over.
+type replayableFile struct {
+	data        []byte
+	name        string
+	contentType string
+}
+
+// newReplayableFile reads reader, keeping the name and content type it reports like the SDK does.
+func newReplayableFile(reader io.Reader) (*replayableFile, error) {
+	file := &replayableFile{}
+	if named, ok := reader.(interface{ Name() string }); ok {
+		file.name = named.Name()
+	}
+	if typed, ok := reader.(interface{ ContentType() string }); ok {
+		file.contentType = typed.ContentType()
+	}
+	if reader != nil {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		file.data = data
+	}
+	return file, nil
+}
+
+// reader returns a new reader of the whole file for a request.
+func (f *replayableFile) reader() io.Reader {
+	return openai.FileParam(bytes.NewReader(f.data), cmp.Or(f.name, "anonymous_file"), cmp.Or(f.contentType, "application/octet-stream")).Value
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Transcribes the audio file of body into the language of the audio.
+// It is subject to the requests per minute quota, the circuit breaker and the stats of the server like NewCompletion,
+// and fails with the same errors. The file of body is read by the request, so it cannot be sent twice.
+//   - options - AudioTranscriptionNewParams contains the optional parameters for the Client.Audio.Transcriptions.New method.
+func (s *RouterServer) NewTranscription(ctx context.Context, body openai.AudioTranscriptionNewParams, opts ...option.RequestOption) (*openai.Transcription, error) {
+	modelName := s.modelName(body.Model.String())
+	return flight(s, modelName, 0, func() (*openai.Transcription, int64, error) {
+		transcription, err := s.client.Audio.Transcriptions.New(ctx, body, s.requestOptions(modelName, opts)...)
+		return transcription, 0, err
+	})
+}
+
+// Translates the audio file of body into English.
+// It is accounted and fails like NewTranscription.
+//   - options - AudioTranslationNewParams contains the optional parameters for the Client.Audio.Translations.New method.
+func (s *RouterServer) NewTranslation(ctx context.Context, body openai.AudioTranslationNewParams, opts ...option.RequestOption) (*openai.Translation, error) {
+	modelName := s.modelName(body.Model.String())
+	return flight(s, modelName, 0, func() (*openai.Translation, int64, error) {
+		translation, err := s.client.Audio.Translations.New(ctx, body, s.requestOptions(modelName, opts)...)
+		return translation, 0, err
+	})
+}
+
+// Generates audio from the input text of body. The caller must close the body of the returned response,
+// which is the audio. The latency of the request is the time until the audio starts to be received.
+// It is accounted and fails like NewTranscription.
+//   - options - AudioSpeechNewParams contains the optional parameters for the Client.Audio.Speech.New method.
+func (s *RouterServer) NewSpeech(ctx context.Context, body openai.AudioSpeechNewParams, opts ...option.RequestOption) (*http.Response, error) {
+	modelName := s.modelName(body.Model.String())
+	return flight(s, modelName, 0, func() (*http.Response, int64, error) {
+		speech, err := s.client.Audio.Speech.New(ctx, body, s.requestOptions(modelName, opts)...)
+		return speech, 0, err
+	})
+}
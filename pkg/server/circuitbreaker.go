@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+const (
+	DefaultConsecutiveFailures = 5
+	DefaultCircuitWindowSize   = 20
+	DefaultCircuitMinRequests  = 10
+	DefaultCircuitCoolDown     = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned by a RouterServer that refuses a request because its circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every request until the cool down period is over.
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe request through to decide whether to close or re-open the circuit.
+	CircuitHalfOpen
+)
+
+func (c CircuitState) String() string {
+	switch c {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerConfig represents the circuit breaker configuration of a server.
+// Zero values are replaced by the defaults.
+type CircuitBreakerConfig struct {
+	Disabled            bool
+	ConsecutiveFailures int           // ConsecutiveFailures is the number of failures in a row that opens the circuit.
+	ErrorRateThreshold  float64       // ErrorRateThreshold is the failure ratio (0-1) over the last WindowSize requests that opens the circuit. Zero disables it.
+	WindowSize          int           // WindowSize is the number of most recent requests the error rate is computed on.
+	MinRequests         int           // MinRequests is the number of requests in the window needed before the error rate is considered.
+	CoolDown            time.Duration // CoolDown is how long the circuit stays open before a probe request is let through.
+	// OnStateChange is called every time the circuit of the server changes state.
+	OnStateChange func(endpoint string, from, to CircuitState)
+}
+
+type circuitBreaker struct {
+	mu                  sync.Mutex
+	config              CircuitBreakerConfig
+	endpoint            string
+	state               CircuitState
+	consecutiveFailures int
+	outcomes            []bool // outcomes is a ring buffer of the most recent requests, true being a failure.
+	next                int
+	failures            int
+	openedAt            time.Time
+	probing             bool
+	now                 func() time.Time
+}
+
+func newCircuitBreaker(endpoint string, config CircuitBreakerConfig) *circuitBreaker {
+	if config.ConsecutiveFailures <= 0 {
+		config.ConsecutiveFailures = DefaultConsecutiveFailures
+	}
+	if config.WindowSize <= 0 {
+		config.WindowSize = DefaultCircuitWindowSize
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = DefaultCircuitMinRequests
+	}
+	if config.CoolDown <= 0 {
+		config.CoolDown = DefaultCircuitCoolDown
+	}
+	return &circuitBreaker{
+		config:   config,
+		endpoint: endpoint,
+		state:    CircuitClosed,
+		outcomes: make([]bool, 0, config.WindowSize),
+		now:      time.Now,
+	}
+}
+
+// State returns the current state of the circuit, reporting an open circuit whose cool down is over as half-open.
+func (b *circuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.config.CoolDown {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// ready reports whether a request would be let through without changing the state of the circuit.
+func (b *circuitBreaker) ready() bool {
+	if b.config.Disabled {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		return b.now().Sub(b.openedAt) >= b.config.CoolDown
+	case CircuitHalfOpen:
+		return !b.probing
+	default:
+		return true
+	}
+}
+
+// acquire reports whether a request can be sent, moving an open circuit whose cool down is over to half-open.
+// In the half-open state only a single probe request is let through at a time.
+func (b *circuitBreaker) acquire() bool {
+	if b.config.Disabled {
+		return true
+	}
+	b.mu.Lock()
+	from := b.state
+	allowed := true
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Sub(b.openedAt) < b.config.CoolDown {
+			allowed = false
+			break
+		}
+		b.state = CircuitHalfOpen
+		b.probing = true
+	case CircuitHalfOpen:
+		if b.probing {
+			allowed = false
+			break
+		}
+		b.probing = true
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+	return allowed
+}
+
+// record updates the circuit with the outcome of a request that was let through by acquire.
+func (b *circuitBreaker) record(err error) {
+	if b.config.Disabled {
+		return
+	}
+	b.mu.Lock()
+	from := b.state
+	if errors.Is(err, context.Canceled) {
+		// The caller gave up, this says nothing about the health of the server.
+		if b.state == CircuitHalfOpen {
+			b.probing = false
+		}
+		b.mu.Unlock()
+		return
+	}
+	failure := isServerFault(err)
+	switch b.state {
+	case CircuitHalfOpen:
+		b.probing = false
+		if failure {
+			b.open()
+		} else {
+			b.close()
+		}
+	case CircuitClosed:
+		b.observe(failure)
+		if b.consecutiveFailures >= b.config.ConsecutiveFailures || b.errorRateExceeded() {
+			b.open()
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+func (b *circuitBreaker) observe(failure bool) {
+	if failure {
+		b.consecutiveFailures++
+	} else {
+		b.consecutiveFailures = 0
+	}
+	if len(b.outcomes) < b.config.WindowSize {
+		b.outcomes = append(b.outcomes, failure)
+	} else {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+		b.outcomes[b.next] = failure
+	}
+	b.next = (b.next + 1) % b.config.WindowSize
+	if failure {
+		b.failures++
+	}
+}
+
+func (b *circuitBreaker) errorRateExceeded() bool {
+	if b.config.ErrorRateThreshold <= 0 || len(b.outcomes) < b.config.MinRequests {
+		return false
+	}
+	return float64(b.failures)/float64(len(b.outcomes)) >= b.config.ErrorRateThreshold
+}
+
+func (b *circuitBreaker) open() {
+	b.state = CircuitOpen
+	b.openedAt = b.now()
+}
+
+func (b *circuitBreaker) close() {
+	b.state = CircuitClosed
+	b.consecutiveFailures = 0
+	b.outcomes = b.outcomes[:0]
+	b.next = 0
+	b.failures = 0
+}
+
+func (b *circuitBreaker) notify(from, to CircuitState) {
+	if from == to {
+		return
+	}
+	slog.Info("Circuit Breaker State Change", "endpoint", b.endpoint, "from", from.String(), "to", to.String())
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(b.endpoint, from, to)
+	}
+}
+
+// isServerFault reports whether err indicates that the server, rather than the request, is at fault.
+// Server side errors, request timeouts and network failures are faults; client errors and throttling are not.
+func isServerFault(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 || apiErr.StatusCode == 408
+	}
+	if errors.Is(err, ErrStreamFailed) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
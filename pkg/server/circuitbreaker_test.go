@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	b, clock := getCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 3, CoolDown: time.Minute})
+	serverErr := &openai.Error{StatusCode: http.StatusInternalServerError}
+	for i := 0; i < 2; i++ {
+		b.acquire()
+		b.record(serverErr)
+	}
+	if b.State() != CircuitClosed {
+		t.Fatalf("Circuit should still be closed, got %s", b.State())
+	}
+	b.acquire()
+	b.record(serverErr)
+	if b.State() != CircuitOpen {
+		t.Fatalf("Circuit should be open, got %s", b.State())
+	}
+	if b.ready() || b.acquire() {
+		t.Fatal("An open circuit should not let requests through")
+	}
+
+	*clock = clock.Add(time.Minute)
+	if !b.ready() {
+		t.Fatal("Circuit should be ready for a probe after the cool down")
+	}
+	if !b.acquire() {
+		t.Fatal("Circuit should let a probe through after the cool down")
+	}
+	if b.ready() || b.acquire() {
+		t.Fatal("A half-open circuit should only let a single probe through")
+	}
+	b.record(nil)
+	if b.State() != CircuitClosed {
+		t.Fatalf("A successful probe should close the circuit, got %s", b.State())
+	}
+}
+
+func TestCircuitBreakerFailedProbe(t *testing.T) {
+	b, clock := getCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 1, CoolDown: time.Minute})
+	b.acquire()
+	b.record(context.DeadlineExceeded)
+	*clock = clock.Add(time.Minute)
+	b.acquire()
+	b.record(&openai.Error{StatusCode: http.StatusBadGateway})
+	if b.State() != CircuitOpen {
+		t.Fatalf("A failed probe should re-open the circuit, got %s", b.State())
+	}
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	b, _ := getCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 100, ErrorRateThreshold: 0.5, WindowSize: 10, MinRequests: 4})
+	serverErr := &openai.Error{StatusCode: http.StatusServiceUnavailable}
+	for _, err := range []error{nil, serverErr, nil} {
+		b.acquire()
+		b.record(err)
+	}
+	if b.State() != CircuitClosed {
+		t.Fatalf("Circuit should be closed below the minimum number of requests, got %s", b.State())
+	}
+	b.acquire()
+	b.record(serverErr)
+	if b.State() != CircuitOpen {
+		t.Fatalf("Circuit should open when the error rate is reached, got %s", b.State())
+	}
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	b, _ := getCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 1})
+	for _, err := range []error{
+		&openai.Error{StatusCode: http.StatusBadRequest},
+		&openai.Error{StatusCode: http.StatusTooManyRequests},
+		context.Canceled,
+		errors.New("invalid request"),
+	} {
+		b.acquire()
+		b.record(err)
+	}
+	if b.State() != CircuitClosed {
+		t.Fatalf("Client errors should not open the circuit, got %s", b.State())
+	}
+}
+
+func TestCircuitBreakerStateChange(t *testing.T) {
+	transitions := []CircuitState{}
+	b, clock := getCircuitBreaker(CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		CoolDown:            time.Second,
+		OnStateChange: func(endpoint string, from, to CircuitState) {
+			transitions = append(transitions, to)
+		},
+	})
+	b.acquire()
+	b.record(context.DeadlineExceeded)
+	*clock = clock.Add(time.Second)
+	b.acquire()
+	b.record(nil)
+	expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(transitions) != len(expected) {
+		t.Fatalf("Incorrect transitions %v", transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Fatalf("Incorrect transitions %v", transitions)
+		}
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b, _ := getCircuitBreaker(CircuitBreakerConfig{Disabled: true, ConsecutiveFailures: 1})
+	b.acquire()
+	b.record(context.DeadlineExceeded)
+	if !b.ready() || !b.acquire() {
+		t.Fatal("A disabled circuit breaker should always let requests through")
+	}
+}
+
+func getCircuitBreaker(config CircuitBreakerConfig) (*circuitBreaker, *time.Time) {
+	clock := time.Now()
+	b := newCircuitBreaker("https://azure-openai.com", config)
+	b.now = func() time.Time { return clock }
+	return b, &clock
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"log/slog"
+	"maps"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// cooldownTracker keeps the models of a server that were throttled and the time until which they should not be used.
+type cooldownTracker struct {
+	mu       sync.Mutex
+	endpoint string
+	models   map[string]time.Time
+	onStart  func(endpoint string, modelName string, until time.Time)
+	now      func() time.Time
+}
+
+func newCooldownTracker(endpoint string, onStart func(endpoint string, modelName string, until time.Time)) *cooldownTracker {
+	return &cooldownTracker{endpoint: endpoint, models: map[string]time.Time{}, onStart: onStart, now: time.Now}
+}
+
+// observe puts modelName into cooldown when res is a 429 with a Retry-After, and takes it out of cooldown
+// when res is successful.
+func (c *cooldownTracker) observe(modelName string, res *http.Response) {
+	now := c.now()
+	if res.StatusCode < 300 {
+		c.mu.Lock()
+		delete(c.models, modelName)
+		c.mu.Unlock()
+		return
+	}
+	if res.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+	retryAfter, ok := parseRetryAfter(res.Header, now)
+	if !ok {
+		return
+	}
+	until := now.Add(retryAfter)
+	c.mu.Lock()
+	if until.Before(c.models[modelName]) {
+		c.mu.Unlock()
+		return
+	}
+	c.models[modelName] = until
+	c.mu.Unlock()
+	slog.Info("Server Throttled", "endpoint", c.endpoint, "model", modelName, "until", until)
+	if c.onStart != nil {
+		c.onStart(c.endpoint, modelName, until)
+	}
+}
+
+// until returns the end of the cooldown of modelName, and false if the model is not in cooldown.
+func (c *cooldownTracker) until(modelName string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until, ok := c.models[modelName]
+	if !ok || !c.now().Before(until) {
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// active returns the models that are in cooldown and the end of their cooldown.
+func (c *cooldownTracker) active() map[string]time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	maps.DeleteFunc(c.models, func(modelName string, until time.Time) bool {
+		return !now.Before(until)
+	})
+	return maps.Clone(c.models)
+}
+
+// parseRetryAfter reads the retry-after-ms header, or the Retry-After header in seconds or as an HTTP date.
+func parseRetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	value := header.Get("retry-after")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now), true
+	}
+	return 0, false
+}
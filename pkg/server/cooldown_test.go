@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		header   http.Header
+		expected time.Duration
+		ok       bool
+	}{
+		{http.Header{"Retry-After-Ms": {"1500"}}, 1500 * time.Millisecond, true},
+		{http.Header{"Retry-After": {"20"}}, 20 * time.Second, true},
+		{http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"1"}}, 250 * time.Millisecond, true},
+		{http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}}, time.Minute, true},
+		{http.Header{"Retry-After": {"soon"}}, 0, false},
+		{http.Header{}, 0, false},
+	}
+	for _, c := range cases {
+		retryAfter, ok := parseRetryAfter(c.header, now)
+		if ok != c.ok || retryAfter != c.expected {
+			t.Fatalf("Incorrect Retry-After for %v, got %v", c.header, retryAfter)
+		}
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	benched := []string{}
+	c := newCooldownTracker("https://azure-openai.com", func(endpoint string, modelName string, until time.Time) {
+		benched = append(benched, modelName)
+	})
+	clock := time.Unix(1000, 0)
+	c.now = func() time.Time { return clock }
+
+	c.observe("gpt-4o", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"10"}}})
+	if until, ok := c.until("gpt-4o"); !ok || !until.Equal(clock.Add(10*time.Second)) {
+		t.Fatalf("Model should be in cooldown, got %v", until)
+	}
+	if _, ok := c.until("gpt-35-turbo"); ok {
+		t.Fatal("Only the throttled model should be in cooldown")
+	}
+	if len(c.active()) != 1 || len(benched) != 1 {
+		t.Fatalf("Incorrect active cooldowns %v", c.active())
+	}
+
+	clock = clock.Add(10 * time.Second)
+	if _, ok := c.until("gpt-4o"); ok {
+		t.Fatal("Cooldown should be over")
+	}
+
+	c.observe("gpt-4o", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After-Ms": {"500"}}})
+	c.observe("gpt-4o", &http.Response{StatusCode: http.StatusOK, Header: http.Header{}})
+	if _, ok := c.until("gpt-4o"); ok {
+		t.Fatal("A successful response should end the cooldown")
+	}
+
+	c.observe("gpt-4o", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}})
+	if _, ok := c.until("gpt-4o"); ok {
+		t.Fatal("A 429 without Retry-After should not put the model into cooldown")
+	}
+}
+
+func TestCooldownFromResponse(t *testing.T) {
+	requests := atomic.Int32{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":{"message":"throttled","type":"rate_limit"}}`))
+	}))
+	defer ts.Close()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            AzureOpenAiServerType,
+		Endpoint:        ts.URL,
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		AvailableModels: []string{"gpt-4o", "gpt-4-turbo"},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if _, err := s.NewCompletion(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT4o)}); err == nil {
+		t.Fatal("Error was expected for a throttled request")
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("A model in cooldown should not be retried, got %d requests", requests.Load())
+	}
+	if s.IsAvailable("gpt-4o") {
+		t.Fatal("Server should not be available for a model in cooldown")
+	}
+	if !s.IsAvailable("gpt-4-turbo") {
+		t.Fatal("Server should still be available for the other models")
+	}
+	if _, ok := s.Cooldowns()["gpt-4o"]; !ok {
+		t.Fatalf("Incorrect cooldowns %v", s.Cooldowns())
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/option"
+)
+
+const (
+	// defaultDiscoveryInterval is the time between two discoveries of the models of a server.
+	defaultDiscoveryInterval = 5 * time.Minute
+	// discoveryTimeout is the maximum duration of a discovery.
+	discoveryTimeout = 30 * time.Second
+	// azureDeploymentsAPIVersion is the last api-version serving the deployments of an Azure OpenAI resource.
+	azureDeploymentsAPIVersion = "2022-12-01"
+)
+
+// ModelDiscovery configures the discovery of the models of a server. The discovered models are served by the
+// server next to its AvailableModels and Deployments, which take precedence.
+// Azure OpenAI servers discover their deployments, the models of the deployments becoming the logical model names.
+// Their deployments are listed with the 2022-12-01 api-version whatever their AzureAPIVersion, the later ones no
+// longer serving them.
+// The other servers discover the models listed by their Models API.
+// The first discovery runs in the background, so that creating a server does not wait for it: until it finishes,
+// the server only serves its AvailableModels and Deployments.
+type ModelDiscovery struct {
+	Enabled  bool
+	Interval time.Duration // Interval is the time between two discoveries, 5 minutes by default.
+}
+
+// discoveredModels keeps the models of a server that were discovered, mapped to their deployment or model name.
+type discoveredModels struct {
+	mu     sync.RWMutex
+	models map[string]string
+}
+
+func (d *discoveredModels) get() map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.models
+}
+
+func (d *discoveredModels) set(models map[string]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.models = models
+}
+
+// azureDeployments is the list of deployments of an Azure OpenAI resource.
+type azureDeployments struct {
+	Data []struct {
+		ID    string `json:"id"`
+		Model string `json:"model"`
+	} `json:"data"`
+}
+
+// DiscoverModels queries the models the server serves and replaces the previously discovered ones.
+// It is called periodically when ServerConfig.Discovery is enabled, and can be called at any time.
+func (s *RouterServer) DiscoverModels(ctx context.Context) error {
+	var models map[string]string
+	var err error
+	if s.Type == AzureOpenAiServerType {
+		models, err = s.listDeployments(ctx)
+	} else {
+		models, err = s.listModels(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Debug("Discovered Models", "endpoint", s.Endpoint, "models", slices.Sorted(maps.Keys(models)))
+	s.discovered.set(models)
+	return nil
+}
+
+// listDeployments lists the deployments of an Azure OpenAI server, mapped from their model. The deployments route
+// of the data plane responds 404 with the api-versions after azureDeploymentsAPIVersion, which it always uses.
+func (s *RouterServer) listDeployments(ctx context.Context) (map[string]string, error) {
+	var deployments azureDeployments
+	if err := s.client.Get(ctx, "deployments", nil, &deployments, option.WithQuery("api-version", azureDeploymentsAPIVersion)); err != nil {
+		return nil, err
+	}
+	models := map[string]string{}
+	for _, deployment := range deployments.Data {
+		modelName := cmp.Or(deployment.Model, deployment.ID)
+		// A deployment named after its model is preferred to the other deployments of the model.
+		if models[modelName] != modelName {
+			models[modelName] = deployment.ID
+		}
+	}
+	return models, nil
+}
+
+// listModels lists the models of the Models API of the server, each served under its own name.
+func (s *RouterServer) listModels(ctx context.Context) (map[string]string, error) {
+	models := map[string]string{}
+	iter := s.client.Models.ListAutoPaging(ctx)
+	for iter.Next() {
+		models[iter.Current().ID] = iter.Current().ID
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// discover discovers the models of the server, logging the failures instead of returning them.
+func (s *RouterServer) discover() {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	if err := s.DiscoverModels(ctx); err != nil {
+		slog.Warn("Model Discovery Failed", "endpoint", s.Endpoint, "error", err)
+	}
+}
+
+// discoverPeriodically discovers the models of the server right away and then every interval, until the server
+// is closed.
+func (s *RouterServer) discoverPeriodically(interval time.Duration) {
+	s.discover()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.discover()
+		}
+	}
+}
+
+// Models returns the models served by the server, its AvailableModels followed by the discovered models that
+// are not part of them.
+func (s *RouterServer) Models() []string {
+	models := slices.Clone(s.AvailableModels)
+	for _, modelName := range slices.Sorted(maps.Keys(s.discovered.get())) {
+		if !slices.Contains(models, modelName) {
+			models = append(models, modelName)
+		}
+	}
+	return models
+}
+
+// hasModel reports whether the server serves modelName.
+func (s *RouterServer) hasModel(modelName string) bool {
+	if slices.Contains(s.AvailableModels, modelName) {
+		return true
+	}
+	_, ok := s.discovered.get()[modelName]
+	return ok
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiscoverAzureDeployments(t *testing.T) {
+	deployments := atomic.Value{}
+	deployments.Store(`[{"id":"gpt4o-eastus","model":"gpt-4o"},{"id":"gpt-4o","model":"gpt-4o"},{"id":"embeddings","model":"text-embedding-3-small"}]`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/openai/deployments" || req.URL.Query().Get("api-version") != "2022-12-01" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":` + deployments.Load().(string) + `}`))
+	}))
+	defer ts.Close()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            AzureOpenAiServerType,
+		Endpoint:        ts.URL,
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		Discovery:       ModelDiscovery{Enabled: true, Interval: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer s.Close()
+	waitForModel(t, s, "gpt-4o")
+	if !slices.Equal(s.Models(), []string{"gpt-4o", "text-embedding-3-small"}) {
+		t.Fatalf("Incorrect discovered models %v", s.Models())
+	}
+	if !s.IsAvailable("gpt-4o") || s.IsAvailable("gpt-4o-mini") {
+		t.Fatal("Only the discovered models should be available")
+	}
+	if s.DeploymentName("gpt-4o") != "gpt-4o" || s.DeploymentName("text-embedding-3-small") != "embeddings" {
+		t.Fatalf("Incorrect deployments %s %s", s.DeploymentName("gpt-4o"), s.DeploymentName("text-embedding-3-small"))
+	}
+	if s.modelName("embeddings") != "text-embedding-3-small" {
+		t.Fatalf("Incorrect model of the deployment %s", s.modelName("embeddings"))
+	}
+
+	deployments.Store(`[{"id":"gpt4o-mini-eastus","model":"gpt-4o-mini"}]`)
+	waitForModel(t, s, "gpt-4o-mini")
+	if s.IsAvailable("gpt-4o") {
+		t.Fatalf("The models should be discovered periodically, got %v", s.Models())
+	}
+}
+
+func TestDiscoverAzureModelsWithoutDeployments(t *testing.T) {
+	var models atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/openai/models" {
+			models.Add(1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"code":"404","message":"Resource not found"}}`))
+	}))
+	defer ts.Close()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            AzureOpenAiServerType,
+		Endpoint:        ts.URL,
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		Discovery:       ModelDiscovery{Enabled: true},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer s.Close()
+	if err := s.DiscoverModels(context.TODO()); err == nil {
+		t.Fatal("The error of the deployments should be returned")
+	}
+	if len(s.Models()) != 0 || models.Load() != 0 {
+		t.Fatalf("The models of the Models API should not be discovered, got %v", s.Models())
+	}
+}
+
+func TestDiscoverModelsMergesConfiguredModels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"llama3","object":"model"},{"id":"mistral","object":"model"}]}`))
+	}))
+	defer ts.Close()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            OpenAiCompatibleServerType,
+		Endpoint:        ts.URL,
+		AvailableModels: []string{"mistral", "qwen"},
+		Discovery:       ModelDiscovery{Enabled: true},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer s.Close()
+	waitForModel(t, s, "llama3")
+	if !slices.Equal(s.Models(), []string{"mistral", "qwen", "llama3"}) {
+		t.Fatalf("Incorrect models %v", s.Models())
+	}
+}
+
+func TestDiscoverModelsFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+	s, err := NewRouterServer(ServerConfig{
+		Type:      OpenAiCompatibleServerType,
+		Endpoint:  ts.URL,
+		Discovery: ModelDiscovery{Enabled: true},
+	})
+	if err != nil {
+		t.Fatalf("A failed discovery should not fail the creation of the server, got %v", err)
+	}
+	defer s.Close()
+	if err := s.DiscoverModels(context.TODO()); err == nil {
+		t.Fatal("The error of the discovery should be returned")
+	}
+	if len(s.Models()) != 0 || s.IsAvailable("llama3") {
+		t.Fatalf("No model should be available, got %v", s.Models())
+	}
+}
+
+func TestDiscoverModelsInBackground(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"llama3","object":"model"}]}`))
+	}))
+	defer ts.Close()
+	defer close(release)
+	start := time.Now()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            OpenAiCompatibleServerType,
+		Endpoint:        ts.URL,
+		AvailableModels: []string{"mistral"},
+		Discovery:       ModelDiscovery{Enabled: true},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	defer s.Close()
+	if time.Since(start) > time.Second {
+		t.Fatalf("The creation of the server should not wait for the discovery, took %v", time.Since(start))
+	}
+	if !slices.Equal(s.Models(), []string{"mistral"}) {
+		t.Fatalf("Only the configured models should be served before the discovery, got %v", s.Models())
+	}
+	release <- struct{}{}
+	waitForModel(t, s, "llama3")
+}
+
+// waitForModel waits up to a second for modelName to be available on s.
+func waitForModel(t *testing.T, s *RouterServer, modelName string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !s.IsAvailable(modelName) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !s.IsAvailable(modelName) {
+		t.Fatalf("%s was not discovered in time, got %v", modelName, s.Models())
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+const (
+	DefaultUnhealthyThreshold = 3
+	DefaultHealthyThreshold   = 2
+	DefaultHealthCheckTimeout = 10 * time.Second
+)
+
+// HealthProbe is the request a health check sends to a server.
+type HealthProbe string
+
+const (
+	// ModelsProbe lists the models of the server. It checks the whole server with a single cheap request.
+	ModelsProbe HealthProbe = "models"
+	// CompletionProbe asks each model of HealthCheckConfig.Models for a 1 token chat completion. It checks every
+	// model separately, at the cost of a few tokens. The models must be chat models, which is why they are not
+	// taken from the models of the server.
+	CompletionProbe HealthProbe = "completion"
+)
+
+// HealthCheckConfig represents the health check configuration of a server. The checks are run by the router,
+// see router.WithHealthChecks. Zero values are replaced by the defaults.
+type HealthCheckConfig struct {
+	Probe              HealthProbe   // Probe is the request sent to the server, ModelsProbe by default.
+	Models             []string      // Models are the chat models checked by the CompletionProbe, which requires them.
+	UnhealthyThreshold int           // UnhealthyThreshold is the number of failed checks in a row that marks the server or model unhealthy.
+	HealthyThreshold   int           // HealthyThreshold is the number of successful checks in a row that marks it healthy again.
+	Timeout            time.Duration // Timeout is the maximum duration of a check.
+}
+
+// healthState counts the consecutive outcomes of the checks of a server or of one of its models.
+type healthState struct {
+	unhealthy bool
+	failures  int
+	successes int
+}
+
+// healthTracker keeps the health of a server, and of its models for the completion probe.
+type healthTracker struct {
+	mu       sync.Mutex
+	config   HealthCheckConfig
+	endpoint string
+	server   healthState
+	models   map[string]*healthState
+}
+
+func newHealthTracker(endpoint string, config HealthCheckConfig) *healthTracker {
+	config.Probe = cmp.Or(config.Probe, ModelsProbe)
+	if config.UnhealthyThreshold <= 0 {
+		config.UnhealthyThreshold = DefaultUnhealthyThreshold
+	}
+	if config.HealthyThreshold <= 0 {
+		config.HealthyThreshold = DefaultHealthyThreshold
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultHealthCheckTimeout
+	}
+	return &healthTracker{config: config, endpoint: endpoint, models: map[string]*healthState{}}
+}
+
+// record records the outcome of a check of modelName, or of the whole server when modelName is empty.
+// A throttled check is neither a success nor a failure, the server is up but has no capacity left to tell more.
+func (h *healthTracker) record(modelName string, err error) {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state := &h.server
+	if modelName != "" {
+		if _, ok := h.models[modelName]; !ok {
+			h.models[modelName] = &healthState{}
+		}
+		state = h.models[modelName]
+	}
+	if err != nil {
+		state.failures++
+		state.successes = 0
+		if !state.unhealthy && state.failures >= h.config.UnhealthyThreshold {
+			state.unhealthy = true
+			slog.Warn("Server Unhealthy", "endpoint", h.endpoint, "model", modelName, "error", err)
+		}
+		return
+	}
+	state.successes++
+	state.failures = 0
+	if state.unhealthy && state.successes >= h.config.HealthyThreshold {
+		state.unhealthy = false
+		slog.Info("Server Healthy", "endpoint", h.endpoint, "model", modelName)
+	}
+}
+
+// healthy reports whether the server and modelName passed their last checks.
+func (h *healthTracker) healthy(modelName string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.server.unhealthy {
+		return false
+	}
+	state, ok := h.models[modelName]
+	return !ok || !state.unhealthy
+}
+
+// IsHealthy reports whether the server, and modelName on the server, passed their last health checks.
+// Servers that were never checked are healthy.
+func (s *RouterServer) IsHealthy(modelName string) bool {
+	return s.health.healthy(modelName)
+}
+
+// CheckHealth sends the health check probe of the server and records its outcome. It returns the errors of the
+// probe. It is called periodically by the router when health checks are enabled.
+func (s *RouterServer) CheckHealth(ctx context.Context) error {
+	config := s.health.config
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	if config.Probe != CompletionProbe {
+		_, err := s.client.Models.List(ctx, option.WithMaxRetries(0))
+		s.health.record("", err)
+		return err
+	}
+	errs := []error{}
+	for _, modelName := range config.Models {
+		_, err := s.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+			Model:     openai.F(openai.ChatModel(s.DeploymentName(modelName))),
+			Messages:  openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("ping")}),
+			MaxTokens: openai.F(int64(1)),
+		}, option.WithMaxRetries(0))
+		s.health.record(modelName, err)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealthTrackerThresholds(t *testing.T) {
+	health := newHealthTracker("https://example.com", HealthCheckConfig{UnhealthyThreshold: 2, HealthyThreshold: 2})
+	failure := errors.New("probe failed")
+	health.record("", failure)
+	if !health.healthy("gpt-4o") {
+		t.Fatal("The server should be healthy until the unhealthy threshold is reached")
+	}
+	health.record("", failure)
+	if health.healthy("gpt-4o") {
+		t.Fatal("The server should be unhealthy after 2 failures")
+	}
+	health.record("", nil)
+	if health.healthy("gpt-4o") {
+		t.Fatal("The server should be unhealthy until the healthy threshold is reached")
+	}
+	health.record("", nil)
+	if !health.healthy("gpt-4o") {
+		t.Fatal("The server should be healthy again after 2 successes")
+	}
+
+	health.record("gpt-4o", failure)
+	health.record("gpt-4o", nil)
+	health.record("gpt-4o", failure)
+	if !health.healthy("gpt-4o") {
+		t.Fatal("Only consecutive failures should mark a model unhealthy")
+	}
+	health.record("gpt-4o", failure)
+	if health.healthy("gpt-4o") || !health.healthy("gpt-4o-mini") {
+		t.Fatal("Only the failing model should be unhealthy")
+	}
+}
+
+func TestCheckHealthModelsProbe(t *testing.T) {
+	statusCode := atomic.Int64{}
+	statusCode.Store(http.StatusInternalServerError)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(statusCode.Load()))
+		w.Write([]byte(`{"object":"list","data":[]}`))
+	}))
+	defer ts.Close()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            OpenAiServerType,
+		Endpoint:        ts.URL,
+		ApiKey:          "openai-key",
+		AvailableModels: []string{"gpt-4o"},
+		HealthCheck:     HealthCheckConfig{UnhealthyThreshold: 1, HealthyThreshold: 1},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if err := s.CheckHealth(context.TODO()); err == nil {
+		t.Fatal("The error of the probe should be returned")
+	}
+	if s.IsHealthy("gpt-4o") || s.IsAvailable("gpt-4o") {
+		t.Fatal("An unhealthy server should not be available")
+	}
+	statusCode.Store(http.StatusOK)
+	if err := s.CheckHealth(context.TODO()); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if !s.IsAvailable("gpt-4o") {
+		t.Fatal("The server should be available again")
+	}
+}
+
+func TestCheckHealthCompletionProbe(t *testing.T) {
+	paths := make(chan string, 3)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		paths <- req.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(req.URL.Path, "gpt4o-mini-eastus") {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"message":"test error","type":"test"}}`))
+			return
+		}
+		if strings.Contains(req.URL.Path, "gpt4-eastus") {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"message":"rate limit","type":"test"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"chatcmpl-test","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"finish_reason":"length","message":{"role":"assistant","content":"Hi"}}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`))
+	}))
+	defer ts.Close()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            AzureOpenAiServerType,
+		Endpoint:        ts.URL,
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		Deployments: map[string]string{
+			"gpt-4o":                 "gpt4o-eastus",
+			"gpt-4o-mini":            "gpt4o-mini-eastus",
+			"gpt-4":                  "gpt4-eastus",
+			"text-embedding-3-small": "embeddings-eastus",
+		},
+		HealthCheck: HealthCheckConfig{Probe: CompletionProbe, Models: []string{"gpt-4o", "gpt-4o-mini", "gpt-4"}, UnhealthyThreshold: 1},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if err := s.CheckHealth(context.TODO()); err == nil {
+		t.Fatal("The error of the failing model should be returned")
+	}
+	if len(paths) != 3 {
+		t.Fatalf("Only the configured models should be probed, got %d probes", len(paths))
+	}
+	if !s.IsHealthy("gpt-4o") || s.IsHealthy("gpt-4o-mini") {
+		t.Fatal("Only the failing model should be unhealthy")
+	}
+	if !s.IsHealthy("gpt-4") {
+		t.Fatal("A throttled model should not be unhealthy")
+	}
+
+	_, err = NewRouterServer(ServerConfig{
+		Type:            AzureOpenAiServerType,
+		Endpoint:        ts.URL,
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		Deployments:     map[string]string{"gpt-4o": "gpt4o-eastus"},
+		HealthCheck:     HealthCheckConfig{Probe: CompletionProbe},
+	})
+	if err == nil {
+		t.Fatal("The completion probe should require its models")
+	}
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"context"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Generates images from the prompt of body.
+// It is subject to the requests per minute quota, the circuit breaker and the stats of the server like NewCompletion,
+// and fails with the same errors.
+//   - options - ImageGenerateParams contains the optional parameters for the Client.Images.Generate method.
+func (s *RouterServer) GenerateImages(ctx context.Context, body openai.ImageGenerateParams, opts ...option.RequestOption) (*openai.ImagesResponse, error) {
+	modelName := s.modelName(body.Model.String())
+	return flight(s, modelName, 0, func() (*openai.ImagesResponse, int64, error) {
+		images, err := s.client.Images.Generate(ctx, body, s.requestOptions(modelName, opts)...)
+		return images, 0, err
+	})
+}
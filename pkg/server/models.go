@@ -0,0 +1,22 @@
+package server
+
+import (
+	"context"
+
+	"github.com/openai/openai-go/option"
+)
+
+// ListModels returns the models the server reports it serves, with the Models API of the server.
+// Deployment names that are mapped in ServerConfig.Deployments are returned as their logical model names.
+// The request is not subject to the quota and the circuit breaker of the server.
+func (s *RouterServer) ListModels(ctx context.Context, opts ...option.RequestOption) ([]string, error) {
+	iter := s.client.Models.ListAutoPaging(ctx, opts...)
+	models := []string{}
+	for iter.Next() {
+		models = append(models, s.modelName(iter.Current().ID))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
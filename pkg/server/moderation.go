@@ -0,0 +1,20 @@
+package server
+
+import (
+	"context"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Classifies if the input of body is potentially harmful.
+// It is subject to the requests per minute quota, the circuit breaker and the stats of the server like NewCompletion,
+// and fails with the same errors.
+//   - options - ModerationNewParams contains the optional parameters for the Client.Moderations.New method.
+func (s *RouterServer) NewModeration(ctx context.Context, body openai.ModerationNewParams, opts ...option.RequestOption) (*openai.ModerationNewResponse, error) {
+	modelName := s.modelName(body.Model.String())
+	return flight(s, modelName, 0, func() (*openai.ModerationNewResponse, int64, error) {
+		moderation, err := s.client.Moderations.New(ctx, body, s.requestOptions(modelName, opts)...)
+		return moderation, 0, err
+	})
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// charactersPerToken is the rough number of characters of a request that make up a token.
+const charactersPerToken = 4
+
+// ErrQuotaExceeded is returned by a RouterServer that refuses a request because it would exceed the
+// tokens or requests per minute configured for the model.
+var ErrQuotaExceeded = errors.New("model quota exceeded")
+
+// ModelLimits represents the quota of a model deployed on a server. Zero values mean no limit.
+type ModelLimits struct {
+	TokensPerMinute   int64
+	RequestsPerMinute int64
+}
+
+// slidingWindow counts events over the last minute using one bucket per second.
+type slidingWindow struct {
+	counts  [60]int64
+	seconds [60]int64
+}
+
+func (w *slidingWindow) add(now time.Time, n int64) {
+	second := now.Unix()
+	i := second % int64(len(w.counts))
+	if w.seconds[i] != second {
+		w.seconds[i] = second
+		w.counts[i] = 0
+	}
+	w.counts[i] += n
+}
+
+func (w *slidingWindow) sum(now time.Time) int64 {
+	second := now.Unix()
+	var total int64
+	for i := range w.counts {
+		if second-w.seconds[i] < int64(len(w.counts)) {
+			total += w.counts[i]
+		}
+	}
+	return max(total, 0)
+}
+
+type modelQuota struct {
+	limits   ModelLimits
+	requests slidingWindow
+	tokens   slidingWindow
+}
+
+// quotaTracker keeps the tokens and requests sent to each model of a server over the last minute.
+type quotaTracker struct {
+	mu     sync.Mutex
+	models map[string]*modelQuota
+	now    func() time.Time
+}
+
+func newQuotaTracker(limits map[string]ModelLimits) *quotaTracker {
+	models := make(map[string]*modelQuota, len(limits))
+	for modelName, modelLimits := range limits {
+		models[modelName] = &modelQuota{limits: modelLimits}
+	}
+	return &quotaTracker{models: models, now: time.Now}
+}
+
+// reserve records a request of tokens for modelName, unless it would exceed the limits of the model.
+func (q *quotaTracker) reserve(modelName string, tokens int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	model, ok := q.models[modelName]
+	if !ok {
+		return true
+	}
+	now := q.now()
+	if model.limits.RequestsPerMinute > 0 && model.requests.sum(now)+1 > model.limits.RequestsPerMinute {
+		return false
+	}
+	if limit := model.limits.TokensPerMinute; limit > 0 {
+		// A request larger than the whole limit is only let through when no other tokens are reserved, or it
+		// would never be.
+		if used := model.tokens.sum(now); used > 0 && used+tokens > limit {
+			return false
+		}
+	}
+	model.requests.add(now, 1)
+	model.tokens.add(now, tokens)
+	return true
+}
+
+// settle replaces the estimated tokens of a reserved request with the tokens it actually used.
+func (q *quotaTracker) settle(modelName string, estimated int64, actual int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if model, ok := q.models[modelName]; ok {
+		model.tokens.add(q.now(), actual-estimated)
+	}
+}
+
+// headroom returns the fraction (0-1) of the most constrained limit of modelName that is still available.
+func (q *quotaTracker) headroom(modelName string) float64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	model, ok := q.models[modelName]
+	if !ok {
+		return 1
+	}
+	now := q.now()
+	headroom := 1.0
+	if model.limits.RequestsPerMinute > 0 {
+		headroom = min(headroom, remainingRatio(model.requests.sum(now), model.limits.RequestsPerMinute))
+	}
+	if model.limits.TokensPerMinute > 0 {
+		headroom = min(headroom, remainingRatio(model.tokens.sum(now), model.limits.TokensPerMinute))
+	}
+	return headroom
+}
+
+func remainingRatio(used int64, limit int64) float64 {
+	return max(float64(limit-used)/float64(limit), 0)
+}
+
+// estimateTokens returns a rough estimate of the tokens used by body, counting the text of its messages
+// and the maximum number of tokens it may generate.
+func estimateTokens(body openai.ChatCompletionNewParams) int64 {
+	tokens := messagesTokens(body.Messages.Value)
+	if body.MaxCompletionTokens.Present {
+		tokens += body.MaxCompletionTokens.Value
+	} else if body.MaxTokens.Present {
+		tokens += body.MaxTokens.Value
+	}
+	return tokens
+}
+
+// estimateTextCompletionTokens returns a rough estimate of the tokens used by body, counting the size of the request
+// and the maximum number of tokens it may generate.
+func estimateTextCompletionTokens(body openai.CompletionNewParams) int64 {
+	return requestTokens(body) + body.MaxTokens.Value
+}
+
+// estimateEmbeddingTokens returns a rough estimate of the tokens used by body, the size of its input.
+func estimateEmbeddingTokens(body openai.EmbeddingNewParams) int64 {
+	return requestTokens(body)
+}
+
+// messagesTokens returns a rough estimate of the tokens of the text of messages. Their images, audio and files
+// are not counted, since the size of their data URLs says little about their tokens.
+func messagesTokens(messages []openai.ChatCompletionMessageParamUnion) int64 {
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return 0
+	}
+	var contents []struct {
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return 0
+	}
+	characters := 0
+	for _, message := range contents {
+		var text string
+		if err := json.Unmarshal(message.Content, &text); err == nil {
+			characters += len(text)
+			continue
+		}
+		var parts []struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(message.Content, &parts); err == nil {
+			for _, part := range parts {
+				characters += len(part.Text)
+			}
+		}
+	}
+	return int64(characters / charactersPerToken)
+}
+
+// requestTokens returns a rough estimate of the tokens of the request body.
+func requestTokens(body json.Marshaler) int64 {
+	data, err := body.MarshalJSON()
+	if err != nil {
+		return 0
+	}
+	return int64(len(data) / charactersPerToken)
+}
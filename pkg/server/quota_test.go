@@ -0,0 +1,108 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	w := slidingWindow{}
+	now := time.Unix(1000, 0)
+	w.add(now, 5)
+	w.add(now.Add(30*time.Second), 3)
+	if w.sum(now.Add(30*time.Second)) != 8 {
+		t.Fatalf("Incorrect sum %d", w.sum(now.Add(30*time.Second)))
+	}
+	if w.sum(now.Add(61*time.Second)) != 3 {
+		t.Fatalf("Events older than a minute should be dropped, got %d", w.sum(now.Add(61*time.Second)))
+	}
+	if w.sum(now.Add(2*time.Minute)) != 0 {
+		t.Fatalf("Incorrect sum %d", w.sum(now.Add(2*time.Minute)))
+	}
+}
+
+func TestQuotaReserve(t *testing.T) {
+	q := newQuotaTracker(map[string]ModelLimits{
+		"gpt-4o": {TokensPerMinute: 1000, RequestsPerMinute: 3},
+	})
+	clock := time.Unix(1000, 0)
+	q.now = func() time.Time { return clock }
+
+	if !q.reserve("gpt-4o", 600) {
+		t.Fatal("Request within the quota should be reserved")
+	}
+	if q.reserve("gpt-4o", 600) {
+		t.Fatal("Request exceeding the tokens per minute should not be reserved")
+	}
+	q.settle("gpt-4o", 600, 100)
+	if !q.reserve("gpt-4o", 600) || !q.reserve("gpt-4o", 10) {
+		t.Fatal("Settled tokens should free the quota")
+	}
+	if q.reserve("gpt-4o", 10) {
+		t.Fatal("Request exceeding the requests per minute should not be reserved")
+	}
+	if q.headroom("gpt-4o") != 0 {
+		t.Fatalf("Incorrect headroom %f", q.headroom("gpt-4o"))
+	}
+	clock = clock.Add(time.Minute)
+	if q.headroom("gpt-4o") != 1 {
+		t.Fatalf("Quota should be restored after a minute, got %f", q.headroom("gpt-4o"))
+	}
+	if !q.reserve("gpt-35-turbo", 1000000) || q.headroom("gpt-35-turbo") != 1 {
+		t.Fatal("Models without limits should never run out of quota")
+	}
+	if !q.reserve("gpt-4o", 1500) {
+		t.Fatal("Request exceeding the whole tokens per minute should be reserved when no tokens are")
+	}
+	if q.reserve("gpt-4o", 10) {
+		t.Fatal("Request exceeding the tokens per minute should not be reserved")
+	}
+}
+
+func TestQuotaHeadroom(t *testing.T) {
+	q := newQuotaTracker(map[string]ModelLimits{
+		"gpt-4o": {TokensPerMinute: 1000, RequestsPerMinute: 10},
+	})
+	q.reserve("gpt-4o", 500)
+	if q.headroom("gpt-4o") != 0.5 {
+		t.Fatalf("Headroom should be the most constrained limit, got %f", q.headroom("gpt-4o"))
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	body := openai.ChatCompletionNewParams{
+		Model: openai.F(openai.ChatModelGPT4o),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.UserMessage("Who wrote the Jungle Book?"),
+		}),
+		MaxTokens: openai.F(int64(100)),
+	}
+	tokens := estimateTokens(body)
+	if tokens <= 100 || tokens > 200 {
+		t.Fatalf("Incorrect token estimate %d", tokens)
+	}
+
+	body.Messages = openai.F([]openai.ChatCompletionMessageParamUnion{
+		openai.UserMessageParts(
+			openai.TextPart("What is in this image?"),
+			openai.ImagePart("data:image/png;base64,"+strings.Repeat("iVBORw0KGgo", 40000)),
+		),
+	})
+	if tokens := estimateTokens(body); tokens <= 100 || tokens > 200 {
+		t.Fatalf("The image should not be counted as text, got %d tokens", tokens)
+	}
+}
+
+func TestEstimateEmbeddingTokens(t *testing.T) {
+	body := openai.EmbeddingNewParams{
+		Model: openai.F(openai.EmbeddingModelTextEmbedding3Small),
+		Input: openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings{strings.Repeat("a", 400)}),
+	}
+	tokens := estimateEmbeddingTokens(body)
+	if tokens <= 100 || tokens > 150 {
+		t.Fatalf("Incorrect token estimate %d", tokens)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitTTL is how long reported rate limits are trusted when the server does not say when they reset.
+const rateLimitTTL = time.Minute
+
+// RateLimit is the capacity of a model on a server as reported by the x-ratelimit-* headers of its last response.
+// Counts that were not reported by the server are -1, reset times that were not reported are zero.
+type RateLimit struct {
+	LimitRequests     int64
+	LimitTokens       int64
+	RemainingRequests int64
+	RemainingTokens   int64
+	ResetRequests     time.Time
+	ResetTokens       time.Time
+	UpdatedAt         time.Time
+}
+
+// parseRateLimit reads the x-ratelimit-* headers of a response received at now.
+// It returns false if the response has none of the headers.
+func parseRateLimit(header http.Header, now time.Time) (RateLimit, bool) {
+	rateLimit := RateLimit{
+		LimitRequests:     headerInt(header, "x-ratelimit-limit-requests"),
+		LimitTokens:       headerInt(header, "x-ratelimit-limit-tokens"),
+		RemainingRequests: headerInt(header, "x-ratelimit-remaining-requests"),
+		RemainingTokens:   headerInt(header, "x-ratelimit-remaining-tokens"),
+		ResetRequests:     headerReset(header, "x-ratelimit-reset-requests", now),
+		ResetTokens:       headerReset(header, "x-ratelimit-reset-tokens", now),
+		UpdatedAt:         now,
+	}
+	if rateLimit.RemainingRequests < 0 && rateLimit.RemainingTokens < 0 {
+		return RateLimit{}, false
+	}
+	return rateLimit, true
+}
+
+func headerInt(header http.Header, key string) int64 {
+	value, err := strconv.ParseInt(header.Get(key), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return value
+}
+
+// headerReset parses a reset header, either a duration such as "6m0s" or "20ms" or a number of seconds.
+func headerReset(header http.Header, key string, now time.Time) time.Time {
+	value := header.Get(key)
+	if value == "" {
+		return time.Time{}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d)
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return now.Add(time.Duration(seconds * float64(time.Second)))
+	}
+	return time.Time{}
+}
+
+// headroom returns the fraction (0-1) of the reported capacity that is still available at now.
+func (r RateLimit) headroom(now time.Time) float64 {
+	return min(
+		remainingHeadroom(r.RemainingRequests, r.LimitRequests, r.ResetRequests, r.UpdatedAt, now),
+		remainingHeadroom(r.RemainingTokens, r.LimitTokens, r.ResetTokens, r.UpdatedAt, now),
+	)
+}
+
+func remainingHeadroom(remaining int64, limit int64, reset time.Time, updatedAt time.Time, now time.Time) float64 {
+	if remaining < 0 {
+		return 1
+	}
+	if reset.IsZero() {
+		reset = updatedAt.Add(rateLimitTTL)
+	}
+	if !now.Before(reset) {
+		return 1
+	}
+	if limit > 0 {
+		return remainingRatio(limit-remaining, limit)
+	}
+	if remaining == 0 {
+		return 0
+	}
+	return 1
+}
+
+// rateLimitTracker keeps the last rate limits reported by a server for each model.
+type rateLimitTracker struct {
+	mu     sync.Mutex
+	models map[string]RateLimit
+	now    func() time.Time
+}
+
+func newRateLimitTracker() *rateLimitTracker {
+	return &rateLimitTracker{models: map[string]RateLimit{}, now: time.Now}
+}
+
+func (t *rateLimitTracker) observe(modelName string, header http.Header) {
+	rateLimit, ok := parseRateLimit(header, t.now())
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.models[modelName] = rateLimit
+}
+
+func (t *rateLimitTracker) get(modelName string) (RateLimit, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rateLimit, ok := t.models[modelName]
+	return rateLimit, ok
+}
+
+func (t *rateLimitTracker) headroom(modelName string) float64 {
+	rateLimit, ok := t.get(modelName)
+	if !ok {
+		return 1
+	}
+	return rateLimit.headroom(t.now())
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	header := http.Header{}
+	header.Set("x-ratelimit-limit-requests", "60")
+	header.Set("x-ratelimit-limit-tokens", "150000")
+	header.Set("x-ratelimit-remaining-requests", "59")
+	header.Set("x-ratelimit-remaining-tokens", "149984")
+	header.Set("x-ratelimit-reset-requests", "1s")
+	header.Set("x-ratelimit-reset-tokens", "6m0s")
+	rateLimit, ok := parseRateLimit(header, now)
+	if !ok {
+		t.Fatal("Rate limit headers should have been parsed")
+	}
+	if rateLimit.LimitRequests != 60 || rateLimit.RemainingRequests != 59 || rateLimit.LimitTokens != 150000 || rateLimit.RemainingTokens != 149984 {
+		t.Fatalf("Incorrect rate limit %+v", rateLimit)
+	}
+	if !rateLimit.ResetRequests.Equal(now.Add(time.Second)) || !rateLimit.ResetTokens.Equal(now.Add(6*time.Minute)) {
+		t.Fatalf("Incorrect reset times %+v", rateLimit)
+	}
+
+	if _, ok := parseRateLimit(http.Header{}, now); ok {
+		t.Fatal("A response without rate limit headers should not be parsed")
+	}
+}
+
+func TestRateLimitHeadroom(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rateLimit := RateLimit{
+		LimitRequests:     100,
+		RemainingRequests: 25,
+		LimitTokens:       -1,
+		RemainingTokens:   -1,
+		ResetRequests:     now.Add(10 * time.Second),
+		UpdatedAt:         now,
+	}
+	if rateLimit.headroom(now) != 0.25 {
+		t.Fatalf("Incorrect headroom %f", rateLimit.headroom(now))
+	}
+	if rateLimit.headroom(now.Add(10*time.Second)) != 1 {
+		t.Fatalf("Headroom should be restored after the reset, got %f", rateLimit.headroom(now.Add(10*time.Second)))
+	}
+
+	// Azure only reports the remaining counts.
+	rateLimit = RateLimit{LimitRequests: -1, RemainingRequests: 0, LimitTokens: -1, RemainingTokens: 1200, UpdatedAt: now}
+	if rateLimit.headroom(now) != 0 {
+		t.Fatalf("Incorrect headroom %f", rateLimit.headroom(now))
+	}
+	if rateLimit.headroom(now.Add(rateLimitTTL)) != 1 {
+		t.Fatalf("Reported rate limits should expire, got %f", rateLimit.headroom(now.Add(rateLimitTTL)))
+	}
+}
+
+func TestRateLimitFromResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-ratelimit-remaining-requests", "0")
+		w.Header().Set("x-ratelimit-remaining-tokens", "1000")
+		w.Header().Set("x-ratelimit-reset-requests", "30s")
+		w.Write([]byte(`{"id":"chatcmpl-test","object":"chat.completion","choices":[]}`))
+	}))
+	defer ts.Close()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            AzureOpenAiServerType,
+		Endpoint:        ts.URL,
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		AvailableModels: []string{"gpt-4o"},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if _, err := s.NewCompletion(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT4o)}); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	rateLimit, ok := s.RateLimit("gpt-4o")
+	if !ok || rateLimit.RemainingRequests != 0 || rateLimit.RemainingTokens != 1000 {
+		t.Fatalf("Rate limit should have been captured from the response, got %+v", rateLimit)
+	}
+	if s.IsAvailable("gpt-4o") {
+		t.Fatal("Server should not be available for a model without remaining requests")
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/packages/ssestream"
+)
+
+// responseRetention is how long a server is remembered as the owner of the responses it created.
+const responseRetention = 24 * time.Hour
+
+// ResponseNewParams is the body of a request to the Responses API, which the openai-go version used by the router
+// does not support yet. Extra holds the other parameters of the request, such as tools or reasoning, and is sent
+// as is next to the typed fields.
+type ResponseNewParams struct {
+	Model              string         `json:"model"`
+	Input              any            `json:"input,omitempty"` // Input is a string or a list of input items.
+	Instructions       string         `json:"instructions,omitempty"`
+	PreviousResponseID string         `json:"previous_response_id,omitempty"`
+	MaxOutputTokens    int64          `json:"max_output_tokens,omitempty"`
+	Extra              map[string]any `json:"-"`
+}
+
+func (p ResponseNewParams) MarshalJSON() ([]byte, error) {
+	type params ResponseNewParams
+	data, err := json.Marshal(params(p))
+	if err != nil || len(p.Extra) == 0 {
+		return data, err
+	}
+	typed := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return nil, err
+	}
+	fields := maps.Clone(p.Extra)
+	for key, value := range typed {
+		fields[key] = value
+	}
+	return json.Marshal(fields)
+}
+
+// ResponseUsage is the number of tokens used by a response.
+type ResponseUsage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+	TotalTokens  int64 `json:"total_tokens"`
+}
+
+func (u ResponseUsage) tokenUsage() tokenUsage {
+	return tokenUsage{PromptTokens: u.InputTokens, CompletionTokens: u.OutputTokens}
+}
+
+// Response is a response created with the Responses API. JSON is the whole response as returned by the server,
+// for the fields that are not typed.
+type Response struct {
+	ID     string            `json:"id"`
+	Model  string            `json:"model"`
+	Status string            `json:"status"`
+	Output []json.RawMessage `json:"output"`
+	Usage  ResponseUsage     `json:"usage"`
+	JSON   json.RawMessage   `json:"-"`
+}
+
+func (r *Response) UnmarshalJSON(data []byte) error {
+	type response Response
+	if err := json.Unmarshal(data, (*response)(r)); err != nil {
+		return err
+	}
+	r.JSON = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// OutputText returns the text of the output messages of the response.
+func (r *Response) OutputText() string {
+	var text strings.Builder
+	for _, output := range r.Output {
+		var message struct {
+			Type    string `json:"type"`
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+		}
+		if err := json.Unmarshal(output, &message); err != nil || message.Type != "message" {
+			continue
+		}
+		for _, content := range message.Content {
+			if content.Type == "output_text" {
+				text.WriteString(content.Text)
+			}
+		}
+	}
+	return text.String()
+}
+
+// ResponseStreamEvent is an event of a streamed response, for example "response.output_text.delta".
+// Data is the whole event as sent by the server.
+type ResponseStreamEvent struct {
+	Type string
+	Data json.RawMessage
+}
+
+func (e *ResponseStreamEvent) UnmarshalJSON(data []byte) error {
+	// The stream wraps the events that have an SSE event name into {"event": ..., "data": ...}.
+	var wrapped struct {
+		Event string          `json:"event"`
+		Data  json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err == nil && wrapped.Event != "" && len(wrapped.Data) > 0 {
+		data = wrapped.Data
+	}
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+	e.Type = event.Type
+	e.Data = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// Creates a response with the Responses API.
+// It is subject to the quota, the circuit breaker and the stats of the server like NewCompletion, and fails
+// with the same errors. The server remembers the responses it created, see OwnsResponse.
+func (s *RouterServer) NewResponse(ctx context.Context, body ResponseNewParams, opts ...option.RequestOption) (*Response, error) {
+	modelName := s.modelName(body.Model)
+	tokens := estimateResponseTokens(body)
+	if err := s.admit(modelName, tokens); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	defer s.postFlight(modelName, start)
+	var response *Response
+	err := s.client.Execute(ctx, http.MethodPost, "responses", body, &response, s.requestOptions(modelName, opts)...)
+	s.breaker.record(err)
+	if err != nil {
+		s.quota.settle(modelName, tokens, 0)
+		return nil, err
+	}
+	elapsed := time.Since(start)
+	s.recordThroughput(modelName, response.Usage.tokenUsage(), elapsed, elapsed)
+	s.quota.settle(modelName, tokens, response.Usage.TotalTokens)
+	s.responses.add(response.ID)
+	return response, nil
+}
+
+// Streams a response created with the Responses API.
+// It is accounted like NewStreamingCompletion and fails with the same errors.
+func (s *RouterServer) NewStreamingResponse(ctx context.Context, body ResponseNewParams, options ...option.RequestOption) *ssestream.Stream[ResponseStreamEvent] {
+	modelName := s.modelName(body.Model)
+	tokens := estimateResponseTokens(body)
+	if err := s.admit(modelName, tokens); err != nil {
+		return ssestream.NewStream[ResponseStreamEvent](nil, err)
+	}
+	start := time.Now()
+	var timeToFirstToken time.Duration
+	finish := func(err error, result streamResult) {
+		s.postFlight(modelName, start)
+		s.breaker.record(err)
+		s.quota.settle(modelName, tokens, streamTokens(tokens, err, timeToFirstToken > 0, result))
+		s.responses.add(result.responseID)
+		if err == nil && timeToFirstToken > 0 {
+			s.recordThroughput(modelName, result.usage, timeToFirstToken, time.Since(start)-timeToFirstToken)
+		}
+	}
+	var raw *http.Response
+	opts := append([]option.RequestOption{option.WithJSONSet("stream", true)}, s.requestOptions(modelName, options)...)
+	err := s.client.Execute(ctx, http.MethodPost, "responses", body, &raw, opts...)
+	decoder := ssestream.NewDecoder(raw)
+	if err != nil || decoder == nil {
+		finish(err, streamResult{})
+		return ssestream.NewStream[ResponseStreamEvent](decoder, err)
+	}
+	return ssestream.NewStream[ResponseStreamEvent](newTrackedDecoder(decoder, start, func(elapsed time.Duration) {
+		timeToFirstToken = elapsed
+		s.recordTimeToFirstToken(modelName, elapsed)
+	}, finish), nil)
+}
+
+// OwnsResponse reports whether the server created the response with the given id within the last 24 hours.
+// Requests that continue a response with previous_response_id must be sent to the server that created it.
+func (s *RouterServer) OwnsResponse(id string) bool {
+	return s.responses.owns(id)
+}
+
+// estimateResponseTokens returns a rough estimate of the tokens used by body, counting the size of the request
+// and the maximum number of tokens it may generate.
+func estimateResponseTokens(body ResponseNewParams) int64 {
+	return requestTokens(body) + body.MaxOutputTokens
+}
+
+// responseTracker keeps the ids of the responses created by a server for responseRetention.
+type responseTracker struct {
+	mu    sync.Mutex
+	ids   map[string]time.Time
+	order []string
+	now   func() time.Time
+}
+
+func newResponseTracker() *responseTracker {
+	return &responseTracker{ids: map[string]time.Time{}, now: time.Now}
+}
+
+func (t *responseTracker) add(id string) {
+	if id == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for len(t.order) > 0 && now.Sub(t.ids[t.order[0]]) >= responseRetention {
+		delete(t.ids, t.order[0])
+		t.order = t.order[1:]
+	}
+	if _, ok := t.ids[id]; !ok {
+		t.order = append(t.order, id)
+	}
+	t.ids[id] = now
+}
+
+func (t *responseTracker) owns(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	createdAt, ok := t.ids[id]
+	return ok && t.now().Sub(createdAt) < responseRetention
+}
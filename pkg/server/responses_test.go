@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseNewParamsMarshal(t *testing.T) {
+	body := ResponseNewParams{
+		Model: "gpt-4o",
+		Input: "Who wrote the Jungle Book?",
+		Extra: map[string]any{"store": true, "model": "ignored"},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	got := map[string]any{}
+	json.Unmarshal(data, &got)
+	if got["model"] != "gpt-4o" || got["input"] != "Who wrote the Jungle Book?" || got["store"] != true {
+		t.Fatalf("Incorrect body %s", data)
+	}
+	if _, ok := got["previous_response_id"]; ok {
+		t.Fatalf("Empty fields should be omitted %s", data)
+	}
+}
+
+func TestNewResponse(t *testing.T) {
+	var path string
+	var sent map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path = req.URL.Path
+		body, _ := io.ReadAll(req.Body)
+		json.Unmarshal(body, &sent)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"resp_1","object":"response","model":"gpt-4o","status":"completed","output":[{"type":"reasoning","summary":[]},{"type":"message","role":"assistant","content":[{"type":"output_text","text":"Rudyard Kipling"}]}],"usage":{"input_tokens":12,"output_tokens":4,"total_tokens":16}}`))
+	}))
+	defer ts.Close()
+	s := getServerForEndpoint(t, ts.URL)
+
+	response, err := s.NewResponse(context.TODO(), ResponseNewParams{Model: "gpt-4o", Input: "Who wrote the Jungle Book?", PreviousResponseID: "resp_0"})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if path != "/openai/responses" || sent["previous_response_id"] != "resp_0" {
+		t.Fatalf("Incorrect request %s %v", path, sent)
+	}
+	if response.ID != "resp_1" || response.Usage.TotalTokens != 16 || response.OutputText() != "Rudyard Kipling" || len(response.JSON) == 0 {
+		t.Fatalf("Incorrect response %+v", response)
+	}
+	if !s.OwnsResponse("resp_1") || s.OwnsResponse("resp_0") {
+		t.Fatal("The server should only own the responses it created")
+	}
+	if _, ok := s.ModelThroughput("gpt-4o"); !ok {
+		t.Fatal("The throughput of the response should be recorded")
+	}
+}
+
+func TestNewStreamingResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_2\",\"status\":\"in_progress\"}}\n\n"))
+		w.Write([]byte("event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"Rudyard\"}\n\n"))
+		w.Write([]byte("data: {\"type\":\"response.completed\",\"response\":{\"id\":\"resp_2\",\"status\":\"completed\",\"usage\":{\"input_tokens\":12,\"output_tokens\":4,\"total_tokens\":16}}}\n\n"))
+	}))
+	defer ts.Close()
+	s := getServerForEndpoint(t, ts.URL)
+
+	stream := s.NewStreamingResponse(context.TODO(), ResponseNewParams{Model: "gpt-4o", Input: "Who wrote the Jungle Book?"})
+	types := []string{}
+	for stream.Next() {
+		types = append(types, stream.Current().Type)
+	}
+	if stream.Err() != nil {
+		t.Fatalf("Error was not expected %v", stream.Err())
+	}
+	if len(types) != 3 || types[0] != "response.created" || types[1] != "response.output_text.delta" || types[2] != "response.completed" {
+		t.Fatalf("Incorrect events %v", types)
+	}
+	if !s.OwnsResponse("resp_2") {
+		t.Fatal("The server should own the streamed response")
+	}
+	if s.ActiveConnections.Load() != 0 {
+		t.Fatalf("A stream read until the end should not count as an active connection, got %d", s.ActiveConnections.Load())
+	}
+}
+
+func TestResponseTracker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newResponseTracker()
+	tracker.now = func() time.Time { return now }
+	tracker.add("resp_1")
+	tracker.add("")
+	if !tracker.owns("resp_1") || tracker.owns("") {
+		t.Fatal("Incorrect owned responses")
+	}
+	now = now.Add(responseRetention)
+	if tracker.owns("resp_1") {
+		t.Fatal("Responses should be forgotten after the retention")
+	}
+	tracker.add("resp_2")
+	if len(tracker.ids) != 1 || len(tracker.order) != 1 {
+		t.Fatalf("Expired responses should be pruned, got %v", tracker.ids)
+	}
+}
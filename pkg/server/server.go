@@ -1,9 +1,17 @@
 package server
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/openai/openai-go"
@@ -17,98 +25,525 @@ type ServerConfigType string
 const (
 	AzureOpenAiServerType ServerConfigType = "azure-openai"
 	OpenAiServerType      ServerConfigType = "openai"
+	// OpenAiCompatibleServerType is any server speaking the OpenAI wire format (vLLM, Ollama, LiteLLM, ...).
+	// The ApiKey is optional for this type.
+	OpenAiCompatibleServerType ServerConfigType = "openai-compatible"
 )
 
+// ErrServerDraining is returned by a RouterServer that refuses a request because it is draining.
+var ErrServerDraining = errors.New("server is draining")
+
+// drainPollInterval is how often Drain checks whether the in-flight requests of a server are finished.
+const drainPollInterval = 10 * time.Millisecond
+
 // ServerConfig represents the configuration for the server.
 type ServerConfig struct {
+	Name            string // Name identifies the server, the Endpoint is used when it is empty.
 	Endpoint        string
 	AzureAPIVersion string
 	ApiKey          string
 	Type            ServerConfigType
 	AvailableModels []string // AvailableModels is a list of models that are available for the Azure endpoint. The list of models will vary based on the endpoint.
+	// Deployments maps the logical model names used by the callers of the router to the deployment or model name
+	// of this server, for example "gpt-4o" to "gpt4o-eastus". The mapped models are added to AvailableModels.
+	Deployments    map[string]string
+	Headers        map[string]string      // Headers are sent with every request to the server.
+	QueryParams    map[string]string      // QueryParams are added to the query string of every request to the server.
+	Labels         map[string]string      // Labels are free form attributes of the server, such as its region, for custom strategies.
+	Weight         int                    // Weight is the share of traffic of the server for the weighted round robin strategy, 1 by default.
+	ModelLimits    map[string]ModelLimits // ModelLimits are the tokens and requests per minute quotas of the models of the server.
+	CircuitBreaker CircuitBreakerConfig
+	// OnCooldown is called when a model of the server is throttled with a Retry-After and put into cooldown.
+	OnCooldown  func(endpoint string, modelName string, until time.Time)
+	HealthCheck HealthCheckConfig // HealthCheck configures the probe of the server when the router checks its health.
+	// Discovery discovers the models of the server in the background when it is created and then periodically, so
+	// that AvailableModels can be left empty.
+	Discovery ModelDiscovery
+	// StatsHalfLife is the time after which a latency sample counts half as much in the averages, 1 minute by default.
+	// A shorter half-life reacts faster to a degradation, a longer one is less sensitive to outliers.
+	StatsHalfLife time.Duration
 }
 
 // RouterServer represents the server that the router will use to send requests.
+// It is safe for concurrent use by multiple goroutines.
 type RouterServer struct {
 	client            *openai.Client
-	ActiveConnections int
-	Latency           int64
+	Name              string
+	Endpoint          string
+	Labels            map[string]string
+	ActiveConnections atomic.Int64
+	Latency           atomic.Int64 // Latency is the recent average latency of the server in milliseconds, the whole duration for streamed requests.
+	TimeToFirstToken  atomic.Int64 // TimeToFirstToken is the recent average time until the first event of streamed requests in milliseconds.
 	Type              ServerConfigType
+	Weight            int
+	statsMu           sync.Mutex // statsMu guards the totals, the averages and the models stats.
+	statsHalfLife     time.Duration
 	totalRequests     int64
-	totalLatency      int64
-	AvailableModels   []string // AvailableModels is a list of models that are available for the Azure endpoint. The list of models will vary based on the endpoint.
+	totalStreams      int64
+	latency           decayingAverage
+	timeToFirstToken  decayingAverage
+	models            map[string]*modelStats
+	AvailableModels   []string // AvailableModels is a list of models that are available for the Azure endpoint. The list of models will vary based on the endpoint. See Models for the discovered models.
+	deployments       map[string]string
+	discovered        discoveredModels
+	closeOnce         sync.Once
+	closed            chan struct{}
+	draining          atomic.Bool
+	breaker           *circuitBreaker
+	quota             *quotaTracker
+	rateLimits        *rateLimitTracker
+	cooldowns         *cooldownTracker
+	responses         *responseTracker
+	health            *healthTracker
 }
 
 func NewRouterServer(serverConfig ServerConfig) (*RouterServer, error) {
-	var err error
-	if len(serverConfig.ApiKey) == 0 {
+	if len(serverConfig.ApiKey) == 0 && serverConfig.Type != OpenAiCompatibleServerType {
 		return nil, fmt.Errorf("empty api key")
 	}
 	if len(serverConfig.Endpoint) == 0 {
 		return nil, fmt.Errorf("empty endpoint")
 	}
-	if len(serverConfig.AvailableModels) == 0 {
+	if len(serverConfig.AvailableModels) == 0 && len(serverConfig.Deployments) == 0 && !serverConfig.Discovery.Enabled {
 		return nil, fmt.Errorf("empty available models")
 	}
+	if serverConfig.HealthCheck.Probe == CompletionProbe && len(serverConfig.HealthCheck.Models) == 0 {
+		return nil, fmt.Errorf("empty health check models for the completion probe")
+	}
 	server := &RouterServer{
-		ActiveConnections: 0,
-		totalRequests:     0,
-		Latency:           0,
-		totalLatency:      0,
-		Type:              serverConfig.Type,
-		AvailableModels:   serverConfig.AvailableModels,
+		totalRequests:   0,
+		statsHalfLife:   cmp.Or(serverConfig.StatsHalfLife, defaultStatsHalfLife),
+		Name:            cmp.Or(serverConfig.Name, serverConfig.Endpoint),
+		Endpoint:        serverConfig.Endpoint,
+		Labels:          maps.Clone(serverConfig.Labels),
+		Type:            serverConfig.Type,
+		Weight:          max(serverConfig.Weight, 1),
+		AvailableModels: availableModels(serverConfig),
+		deployments:     maps.Clone(serverConfig.Deployments),
+		breaker:         newCircuitBreaker(serverConfig.Endpoint, serverConfig.CircuitBreaker),
+		quota:           newQuotaTracker(serverConfig.ModelLimits),
+		rateLimits:      newRateLimitTracker(),
+		models:          map[string]*modelStats{},
+		cooldowns:       newCooldownTracker(serverConfig.Endpoint, serverConfig.OnCooldown),
+		responses:       newResponseTracker(),
+		health:          newHealthTracker(serverConfig.Endpoint, serverConfig.HealthCheck),
+		closed:          make(chan struct{}),
+	}
+	opts, err := clientOptions(serverConfig)
+	if err != nil {
+		return nil, err
+	}
+	server.client = openai.NewClient(opts...)
+	if serverConfig.Discovery.Enabled {
+		go server.discoverPeriodically(cmp.Or(serverConfig.Discovery.Interval, defaultDiscoveryInterval))
+	}
+	return server, nil
+}
+
+// Close stops the background work of the server, such as the discovery of its models.
+// Requests can still be sent to a closed server.
+func (s *RouterServer) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+}
+
+// Drain stops the server from accepting new requests and waits until its in-flight requests, streams included,
+// are finished or ctx is done. A draining server is not available for any model, refuses the requests sent to it
+// anyway with ErrServerDraining and cannot be undrained.
+func (s *RouterServer) Drain(ctx context.Context) error {
+	s.draining.Store(true)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for s.ActiveConnections.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// IsDraining reports whether the server was drained.
+func (s *RouterServer) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// availableModels returns the configured AvailableModels followed by the mapped models that are not part of it.
+func availableModels(serverConfig ServerConfig) []string {
+	models := slices.Clone(serverConfig.AvailableModels)
+	for _, modelName := range slices.Sorted(maps.Keys(serverConfig.Deployments)) {
+		if !slices.Contains(models, modelName) {
+			models = append(models, modelName)
+		}
+	}
+	return models
+}
+
+// clientOptions returns the options used to create the openai client of the server described by serverConfig.
+func clientOptions(serverConfig ServerConfig) ([]option.RequestOption, error) {
+	opts := []option.RequestOption{}
+	// Request paths are resolved relative to the base URL, which drops its last segment without a trailing slash.
+	baseURL := serverConfig.Endpoint
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
 	}
 	switch serverConfig.Type {
 	case AzureOpenAiServerType:
 		if len(serverConfig.AzureAPIVersion) == 0 {
 			return nil, fmt.Errorf("empty version")
 		}
-		client := openai.NewClient(
+		opts = append(opts,
 			azure.WithEndpoint(serverConfig.Endpoint, serverConfig.AzureAPIVersion),
 			azure.WithAPIKey(serverConfig.ApiKey),
 		)
-		server.client = client
-		return server, err
 	case OpenAiServerType:
-		client := openai.NewClient(
+		opts = append(opts,
+			option.WithBaseURL(baseURL),
 			option.WithAPIKey(serverConfig.ApiKey),
 		)
-		server.client = client
-		return server, err
+	case OpenAiCompatibleServerType:
+		opts = append(opts, option.WithBaseURL(baseURL))
+		if len(serverConfig.ApiKey) > 0 {
+			opts = append(opts, option.WithAPIKey(serverConfig.ApiKey))
+		} else {
+			// Do not leak the OPENAI_API_KEY picked up by the openai client to a third party endpoint.
+			opts = append(opts, option.WithHeaderDel("authorization"))
+		}
 	default:
 		return nil, fmt.Errorf("server type %s is not supported", serverConfig.Type)
 	}
+	for key, value := range serverConfig.Headers {
+		opts = append(opts, option.WithHeader(key, value))
+	}
+	for key, value := range serverConfig.QueryParams {
+		opts = append(opts, option.WithQueryAdd(key, value))
+	}
+	return opts, nil
+}
+
+// IsAvailable reports whether the server serves modelName and currently accepts new requests.
+// Servers and models marked unhealthy by the health checks, and draining servers, are not available.
+func (s *RouterServer) IsAvailable(modelName string) bool {
+	if _, throttled := s.cooldowns.until(modelName); throttled || s.IsDraining() {
+		return false
+	}
+	return s.hasModel(modelName) && s.IsHealthy(modelName) && s.breaker.ready() && s.Headroom(modelName) > 0
+}
+
+// CooldownUntil returns the time until which modelName is throttled on the server, and false if it is not.
+func (s *RouterServer) CooldownUntil(modelName string) (time.Time, bool) {
+	return s.cooldowns.until(modelName)
+}
+
+// Cooldowns returns the models of the server that are throttled and the time until which they are.
+func (s *RouterServer) Cooldowns() map[string]time.Time {
+	return s.cooldowns.active()
+}
+
+// Headroom returns the fraction (0-1) of the capacity of modelName that is still available on the server.
+// It is the most constrained of the configured tokens and requests per minute quota and of the
+// rate limits reported by the server. Models without limits always have a headroom of 1.
+func (s *RouterServer) Headroom(modelName string) float64 {
+	return min(s.quota.headroom(modelName), s.rateLimits.headroom(modelName))
+}
+
+// RateLimit returns the rate limits of modelName reported by the x-ratelimit-* headers of the last response
+// of the server, and false if the server never reported them.
+func (s *RouterServer) RateLimit(modelName string) (RateLimit, bool) {
+	return s.rateLimits.get(modelName)
+}
+
+// DeploymentName returns the name modelName is deployed under on the server.
+// Models that are not mapped in ServerConfig.Deployments or discovered are deployed under their own name, and so are
+// the configured AvailableModels.
+func (s *RouterServer) DeploymentName(modelName string) string {
+	if deployment, ok := s.deployments[modelName]; ok {
+		return deployment
+	}
+	if slices.Contains(s.AvailableModels, modelName) {
+		return modelName
+	}
+	if deployment, ok := s.discovered.get()[modelName]; ok {
+		return deployment
+	}
+	return modelName
+}
+
+// modelName returns the logical model name of a request sent to the server for the given deployment.
+func (s *RouterServer) modelName(deployment string) string {
+	for modelName, mapped := range s.deployments {
+		if mapped == deployment {
+			return modelName
+		}
+	}
+	if slices.Contains(s.AvailableModels, deployment) {
+		return deployment
+	}
+	for modelName, mapped := range s.discovered.get() {
+		if mapped == deployment {
+			return modelName
+		}
+	}
+	return deployment
+}
+
+// CircuitState returns the state of the circuit breaker of the server.
+func (s *RouterServer) CircuitState() CircuitState {
+	return s.breaker.State()
 }
 
 // Returns the completion.
-// If the operation fails it returns an error type
+// If the operation fails it returns an error type, ErrCircuitOpen if the circuit breaker of the server is open,
+// ErrQuotaExceeded if the request would exceed the quota of the model and ErrServerDraining if the server is draining.
 //   - options - ChatCompletionNewParams contains the optional parameters for the Client.Chat.Completions.New method.
 func (s *RouterServer) NewCompletion(ctx context.Context, body openai.ChatCompletionNewParams, opts ...option.RequestOption) (*openai.ChatCompletion, error) {
-	s.preFlight()
+	modelName := s.modelName(body.Model.String())
+	tokens := estimateTokens(body)
+	if err := s.admit(modelName, tokens); err != nil {
+		return nil, err
+	}
 	start := time.Now()
-	defer s.postFlight(start)
-	return s.client.Chat.Completions.New(ctx, body, opts...)
+	defer s.postFlight(modelName, start)
+	completion, err := s.client.Chat.Completions.New(ctx, body, s.requestOptions(modelName, opts)...)
+	s.breaker.record(err)
+	if err != nil {
+		s.quota.settle(modelName, tokens, 0)
+		return completion, err
+	}
+	elapsed := time.Since(start)
+	s.recordThroughput(modelName, tokenUsage{PromptTokens: completion.Usage.PromptTokens, CompletionTokens: completion.Usage.CompletionTokens}, elapsed, elapsed)
+	s.quota.settle(modelName, tokens, completion.Usage.TotalTokens)
+	return completion, err
+}
+
+// Returns the completion of a prompt with the legacy completions endpoint, for models such as gpt-3.5-turbo-instruct.
+// It is accounted and fails like NewCompletion.
+//   - options - CompletionNewParams contains the optional parameters for the Client.Completions.New method.
+func (s *RouterServer) NewTextCompletion(ctx context.Context, body openai.CompletionNewParams, opts ...option.RequestOption) (*openai.Completion, error) {
+	modelName := s.modelName(body.Model.String())
+	return flight(s, modelName, estimateTextCompletionTokens(body), func() (*openai.Completion, int64, error) {
+		start := time.Now()
+		completion, err := s.client.Completions.New(ctx, body, s.requestOptions(modelName, opts)...)
+		if err != nil {
+			return nil, 0, err
+		}
+		elapsed := time.Since(start)
+		s.recordThroughput(modelName, tokenUsage{PromptTokens: completion.Usage.PromptTokens, CompletionTokens: completion.Usage.CompletionTokens}, elapsed, elapsed)
+		return completion, completion.Usage.TotalTokens, nil
+	})
 }
 
 // Streams the completion.
 // If the operation fails it returns an error type
+// If the circuit breaker of the server is open the returned stream fails with ErrCircuitOpen, with
+// ErrQuotaExceeded if the request would exceed the quota of the model and with ErrServerDraining if the server
+// is draining.
+// The request counts as an active connection until the stream is read until the end or closed, and its
+// time to first token and whole duration are recorded separately.
 //   - options - ChatCompletionNewParams contains the optional parameters for the Client.Chat.Completions.NewStreaming method.
 func (s *RouterServer) NewStreamingCompletion(ctx context.Context, body openai.ChatCompletionNewParams, options ...option.RequestOption) *ssestream.Stream[openai.ChatCompletionChunk] {
-	s.preFlight()
+	modelName := s.modelName(body.Model.String())
+	tokens := estimateTokens(body)
+	if err := s.admit(modelName, tokens); err != nil {
+		return ssestream.NewStream[openai.ChatCompletionChunk](nil, err)
+	}
 	start := time.Now()
-	defer s.postFlight(start)
-	return s.client.Chat.Completions.NewStreaming(ctx, body, options...)
+	var timeToFirstToken time.Duration
+	finish := func(err error, result streamResult) {
+		s.postFlight(modelName, start)
+		s.breaker.record(err)
+		s.quota.settle(modelName, tokens, streamTokens(tokens, err, timeToFirstToken > 0, result))
+		if err == nil && timeToFirstToken > 0 {
+			s.recordThroughput(modelName, result.usage, timeToFirstToken, time.Since(start)-timeToFirstToken)
+		}
+	}
+	var raw *http.Response
+	opts := append([]option.RequestOption{option.WithJSONSet("stream", true)}, s.requestOptions(modelName, options)...)
+	err := s.client.Execute(ctx, http.MethodPost, "chat/completions", body, &raw, opts...)
+	decoder := ssestream.NewDecoder(raw)
+	if err != nil || decoder == nil {
+		finish(err, streamResult{})
+		return ssestream.NewStream[openai.ChatCompletionChunk](decoder, err)
+	}
+	return ssestream.NewStream[openai.ChatCompletionChunk](newTrackedDecoder(decoder, start, func(elapsed time.Duration) {
+		timeToFirstToken = elapsed
+		s.recordTimeToFirstToken(modelName, elapsed)
+	}, finish), nil)
+}
+
+// Returns the embeddings of the input of body.
+// It is subject to the quota, the circuit breaker and the stats of the server like NewCompletion, and fails
+// with the same errors.
+//   - options - EmbeddingNewParams contains the optional parameters for the Client.Embeddings.New method.
+func (s *RouterServer) NewEmbedding(ctx context.Context, body openai.EmbeddingNewParams, opts ...option.RequestOption) (*openai.CreateEmbeddingResponse, error) {
+	modelName := s.modelName(body.Model.String())
+	return flight(s, modelName, estimateEmbeddingTokens(body), func() (*openai.CreateEmbeddingResponse, int64, error) {
+		embedding, err := s.client.Embeddings.New(ctx, body, s.requestOptions(modelName, opts)...)
+		if err != nil {
+			return nil, 0, err
+		}
+		return embedding, embedding.Usage.TotalTokens, nil
+	})
 }
 
-func (s *RouterServer) preFlight() {
-	s.ActiveConnections++
+// ModelLatency returns the recent latency of the requests sent to the server for modelName, the whole duration
+// for streamed requests, and false if the server has not served the model yet.
+func (s *RouterServer) ModelLatency(modelName string) (LatencyStats, bool) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	stats, ok := s.models[modelName]
+	if !ok || !stats.latency.known() {
+		return LatencyStats{}, false
+	}
+	return stats.latency.stats(time.Now(), s.statsHalfLife), true
 }
 
-func (s *RouterServer) postFlight(start time.Time) {
-	elapsed := time.Since(start)
-	s.ActiveConnections--
+// ModelAverageLatency returns the recent average latency of the requests sent to the server for modelName like
+// ModelLatency, without computing the percentiles, and false if the server has not served the model yet.
+func (s *RouterServer) ModelAverageLatency(modelName string) (time.Duration, bool) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	stats, ok := s.models[modelName]
+	if !ok || !stats.latency.known() {
+		return 0, false
+	}
+	return time.Duration(stats.latency.average.value), true
+}
+
+// ModelTimeToFirstToken returns the recent average time to first token of streamed requests for modelName,
+// and false if the server has not streamed the model yet.
+func (s *RouterServer) ModelTimeToFirstToken(modelName string) (time.Duration, bool) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	stats, ok := s.models[modelName]
+	if !ok || !stats.timeToFirstToken.known() {
+		return 0, false
+	}
+	return time.Duration(stats.timeToFirstToken.average.value), true
+}
+
+// ModelThroughput returns the recent token throughput of the server for modelName, and false if the server has not
+// reported the usage of a completion of the model yet. Streamed requests only report their usage when they set
+// stream_options.include_usage.
+func (s *RouterServer) ModelThroughput(modelName string) (Throughput, bool) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	stats, ok := s.models[modelName]
+	if !ok || stats.timePerOutputToken.updatedAt.IsZero() {
+		return Throughput{}, false
+	}
+	return Throughput{
+		TimePerOutputToken:    time.Duration(stats.timePerOutputToken.value),
+		PromptTokensPerSecond: stats.promptTokensPerSecond.value,
+	}, true
+}
+
+// modelStats returns the stats of modelName, creating them if needed. statsMu must be held.
+func (s *RouterServer) modelStats(modelName string) *modelStats {
+	stats, ok := s.models[modelName]
+	if !ok {
+		stats = &modelStats{}
+		s.models[modelName] = stats
+	}
+	return stats
+}
+
+func (s *RouterServer) recordTimeToFirstToken(modelName string, elapsed time.Duration) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	now := time.Now()
+	s.modelStats(modelName).timeToFirstToken.add(elapsed, now, s.statsHalfLife)
+	s.timeToFirstToken.add(float64(elapsed), now, s.statsHalfLife)
+	s.totalStreams++
+	s.TimeToFirstToken.Store(time.Duration(s.timeToFirstToken.value).Milliseconds())
+	slog.Debug("Average Time To First Token for Server", "averageTimeToFirstToken", s.TimeToFirstToken.Load(), "numberOfStreams", s.totalStreams)
+}
+
+// recordThroughput records the throughput of a completion of modelName whose prompt was processed in promptTime
+// and whose completion tokens were generated in generationTime.
+func (s *RouterServer) recordThroughput(modelName string, usage tokenUsage, promptTime time.Duration, generationTime time.Duration) {
+	if usage.CompletionTokens <= 0 || generationTime <= 0 {
+		return
+	}
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	now := time.Now()
+	stats := s.modelStats(modelName)
+	stats.timePerOutputToken.add(float64(generationTime)/float64(usage.CompletionTokens), now, s.statsHalfLife)
+	if usage.PromptTokens > 0 && promptTime > 0 {
+		stats.promptTokensPerSecond.add(float64(usage.PromptTokens)/promptTime.Seconds(), now, s.statsHalfLife)
+	}
+}
+
+// requestOptions returns opts with the options the server needs to observe the responses to a request for modelName.
+// The retries of the openai client are disabled, overriding opts: a failed request is retried by the router on
+// another server, instead of being sent again to a server that failed or throttled it.
+func (s *RouterServer) requestOptions(modelName string, opts []option.RequestOption) []option.RequestOption {
+	return append(slices.Clip(opts), option.WithMaxRetries(0), option.WithMiddleware(func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		res, err := next(req)
+		if res != nil {
+			s.rateLimits.observe(modelName, res.Header)
+			s.cooldowns.observe(modelName, res)
+		}
+		return res, err
+	}))
+}
+
+// flight sends a request for modelName with call, which returns the tokens the request used. The request reserves
+// tokens of the quota of the model until call returns, is refused when the circuit breaker of the server is open
+// or the server is draining, and counts in the stats of the server.
+func flight[T any](s *RouterServer, modelName string, tokens int64, call func() (T, int64, error)) (T, error) {
+	var zero T
+	if err := s.admit(modelName, tokens); err != nil {
+		return zero, err
+	}
+	defer s.postFlight(modelName, time.Now())
+	res, used, err := call()
+	s.breaker.record(err)
+	s.quota.settle(modelName, tokens, used)
+	return res, err
+}
+
+// admit reserves tokens of the quota of modelName and counts the request as an active connection, or refuses it
+// with ErrQuotaExceeded, ErrServerDraining or ErrCircuitOpen. An admitted request must end with postFlight.
+func (s *RouterServer) admit(modelName string, tokens int64) error {
+	if !s.quota.reserve(modelName, tokens) {
+		return ErrQuotaExceeded
+	}
+	if !s.preFlight() {
+		s.quota.settle(modelName, tokens, 0)
+		return ErrServerDraining
+	}
+	if !s.breaker.acquire() {
+		s.ActiveConnections.Add(-1)
+		s.quota.settle(modelName, tokens, 0)
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// preFlight counts the request as an active connection, unless the server is draining. The draining flag is
+// checked after the request is counted, so that Drain either waits for the request or the request is refused.
+func (s *RouterServer) preFlight() bool {
+	s.ActiveConnections.Add(1)
+	if s.draining.Load() {
+		s.ActiveConnections.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (s *RouterServer) postFlight(modelName string, start time.Time) {
+	now := time.Now()
+	elapsed := now.Sub(start)
+	s.ActiveConnections.Add(-1)
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.modelStats(modelName).latency.add(elapsed, now, s.statsHalfLife)
+	s.latency.add(float64(elapsed), now, s.statsHalfLife)
 	s.totalRequests++
-	s.totalLatency += elapsed.Milliseconds()
-	s.Latency = s.totalLatency / s.totalRequests
-	slog.Debug("Average Latency for Server", "averageLatency", s.Latency, "totalLatency", s.totalLatency, "numberOfRequests", s.totalRequests)
+	s.Latency.Store(time.Duration(s.latency.value).Milliseconds())
+	slog.Debug("Average Latency for Server", "averageLatency", s.Latency.Load(), "model", modelName, "numberOfRequests", s.totalRequests)
 }
@@ -1,8 +1,15 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/openai/openai-go"
 )
 
 func TestNewServer(t *testing.T) {
@@ -29,44 +36,269 @@ func TestNewServer(t *testing.T) {
 	}
 }
 
+func TestNewServerWithoutApiKey(t *testing.T) {
+	serverConfig := ServerConfig{
+		Type:            OpenAiServerType,
+		Endpoint:        "http://localhost:8000/v1",
+		AvailableModels: []string{"llama3"},
+	}
+	if _, err := NewRouterServer(serverConfig); err == nil {
+		t.Fatal("Error was expected for an openai server without api key")
+	}
+	serverConfig.Type = OpenAiCompatibleServerType
+	if _, err := NewRouterServer(serverConfig); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+}
+
+func TestOpenAiEndpoint(t *testing.T) {
+	for _, serverType := range []ServerConfigType{OpenAiServerType, OpenAiCompatibleServerType} {
+		var got *http.Request
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			got = req
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"chatcmpl-test","object":"chat.completion","choices":[]}`))
+		}))
+		s, err := NewRouterServer(ServerConfig{
+			Type:            serverType,
+			Endpoint:        ts.URL + "/v1",
+			ApiKey:          "local-key",
+			AvailableModels: []string{"llama3"},
+			Headers:         map[string]string{"X-Gateway-Team": "travel"},
+			QueryParams:     map[string]string{"tenant": "acai"},
+		})
+		if err != nil {
+			t.Fatalf("Error was not expected %v", err)
+		}
+		_, err = s.NewCompletion(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F("llama3")})
+		ts.Close()
+		if err != nil {
+			t.Fatalf("%s: error was not expected %v", serverType, err)
+		}
+		if got.URL.Path != "/v1/chat/completions" {
+			t.Fatalf("%s: request was not sent to the endpoint, path %s", serverType, got.URL.Path)
+		}
+		if got.Header.Get("Authorization") != "Bearer local-key" {
+			t.Fatalf("%s: incorrect authorization header %s", serverType, got.Header.Get("Authorization"))
+		}
+		if got.Header.Get("X-Gateway-Team") != "travel" || got.URL.Query().Get("tenant") != "acai" {
+			t.Fatalf("%s: default headers and query parameters were not sent", serverType)
+		}
+	}
+}
+
+func TestOpenAiCompatibleWithoutApiKey(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "secret-key")
+	var got *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-test","object":"chat.completion","choices":[]}`))
+	}))
+	defer ts.Close()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            OpenAiCompatibleServerType,
+		Endpoint:        ts.URL,
+		AvailableModels: []string{"llama3"},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if _, err := s.NewCompletion(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F("llama3")}); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if got.Header.Get("Authorization") != "" {
+		t.Fatalf("Authorization header should not be sent without an api key, got %s", got.Header.Get("Authorization"))
+	}
+}
+
+func TestCompletionThroughput(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-test","object":"chat.completion","choices":[],"usage":{"prompt_tokens":50,"completion_tokens":10,"total_tokens":60}}`))
+	}))
+	defer ts.Close()
+	s := getServerForEndpoint(t, ts.URL)
+	if _, ok := s.ModelThroughput("gpt-4o"); ok {
+		t.Fatal("A model that was never served should have no throughput")
+	}
+	if _, err := s.NewCompletion(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT4o)}); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	throughput, ok := s.ModelThroughput("gpt-4o")
+	if !ok || throughput.TimePerOutputToken < 10*time.Millisecond || throughput.TimePerOutputToken >= 100*time.Millisecond {
+		t.Fatalf("Incorrect time per output token %+v", throughput)
+	}
+	if throughput.PromptTokensPerSecond <= 0 || throughput.PromptTokensPerSecond > 500 {
+		t.Fatalf("Incorrect prompt tokens per second %+v", throughput)
+	}
+}
+
+func TestGenerateImagesQuota(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"created":1,"data":[{"url":"https://example.com/image.png"}]}`))
+	}))
+	defer ts.Close()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            AzureOpenAiServerType,
+		Endpoint:        ts.URL,
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		AvailableModels: []string{"dall-e-3"},
+		ModelLimits:     map[string]ModelLimits{"dall-e-3": {RequestsPerMinute: 1}},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	body := openai.ImageGenerateParams{Prompt: openai.F("A tiger"), Model: openai.F(openai.ImageModelDallE3)}
+	if _, err := s.GenerateImages(context.TODO(), body); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if _, ok := s.ModelLatency("dall-e-3"); !ok || s.ActiveConnections.Load() != 0 {
+		t.Fatal("The image generation should be accounted")
+	}
+	if _, err := s.GenerateImages(context.TODO(), body); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("The requests per minute quota should apply to images, got %v", err)
+	}
+}
+
+func TestDeployments(t *testing.T) {
+	s, err := NewRouterServer(ServerConfig{
+		Type:            AzureOpenAiServerType,
+		Endpoint:        "https://azure-openai.com",
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		AvailableModels: []string{"gpt-35-turbo"},
+		Deployments:     map[string]string{"gpt-4o": "gpt4o-eastus"},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	if !s.IsAvailable("gpt-4o") || !s.IsAvailable("gpt-35-turbo") {
+		t.Fatalf("Mapped models should be added to the available models %v", s.AvailableModels)
+	}
+	if s.DeploymentName("gpt-4o") != "gpt4o-eastus" || s.DeploymentName("gpt-35-turbo") != "gpt-35-turbo" {
+		t.Fatal("Incorrect deployment names")
+	}
+	if s.modelName("gpt4o-eastus") != "gpt-4o" || s.modelName("gpt-35-turbo") != "gpt-35-turbo" {
+		t.Fatal("Incorrect logical model names")
+	}
+
+	_, err = NewRouterServer(ServerConfig{
+		Type:        OpenAiServerType,
+		Endpoint:    "https://api.openai.com/v1",
+		ApiKey:      "openai-key",
+		Deployments: map[string]string{"gpt-4o": "gpt-4o-2024-08-06"},
+	})
+	if err != nil {
+		t.Fatalf("A server with only deployments should be valid %v", err)
+	}
+}
+
 func TestPreFlight(t *testing.T) {
 	s := getServer()
-	s.preFlight()
-	if s.ActiveConnections != 1 {
-		t.Fatalf("Incorrect Active Connections calculations %d", s.ActiveConnections)
+	if !s.preFlight() || s.ActiveConnections.Load() != 1 {
+		t.Fatalf("Incorrect Active Connections calculations %d", s.ActiveConnections.Load())
 	}
+	s.draining.Store(true)
+	if s.preFlight() || s.ActiveConnections.Load() != 1 {
+		t.Fatalf("A draining server should refuse the request, got %d Active Connections", s.ActiveConnections.Load())
+	}
+}
 
+func TestDrainRefusesRequests(t *testing.T) {
+	s := getServer()
+	if err := s.Drain(context.TODO()); err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	body := openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT3_5Turbo)}
+	if _, err := s.NewCompletion(context.TODO(), body); !errors.Is(err, ErrServerDraining) {
+		t.Fatalf("Incorrect error %v", err)
+	}
+	stream := s.NewStreamingCompletion(context.TODO(), body)
+	if stream.Next() || !errors.Is(stream.Err(), ErrServerDraining) {
+		t.Fatalf("Incorrect error %v", stream.Err())
+	}
+	if _, err := s.NewEmbedding(context.TODO(), openai.EmbeddingNewParams{Model: openai.F(openai.EmbeddingModelTextEmbedding3Small)}); !errors.Is(err, ErrServerDraining) {
+		t.Fatalf("Incorrect error %v", err)
+	}
+	if s.ActiveConnections.Load() != 0 || s.totalRequests != 0 {
+		t.Fatalf("The refused requests should not be accounted, got %d Active Connections", s.ActiveConnections.Load())
+	}
 }
 
 func TestPostFlight(t *testing.T) {
 	s := getServer()
-	s.ActiveConnections = 10
+	s.ActiveConnections.Store(10)
 	s.totalRequests = 20
-	s.totalLatency = 41
 	start := time.Now().Add(-15 * time.Second)
-	s.postFlight(start)
-	if s.ActiveConnections != 9 {
-		t.Fatalf("Incorrect Active Connections calculations %d", s.ActiveConnections)
+	s.postFlight("gpt-4o", start)
+	if s.ActiveConnections.Load() != 9 {
+		t.Fatalf("Incorrect Active Connections calculations %d", s.ActiveConnections.Load())
 	}
 	if s.totalRequests != 21 {
 		t.Fatalf("Incorrect Total Requests calculations %d", s.totalRequests)
 	}
-	if s.totalLatency <= 41 {
-		t.Fatalf("Incorrect Total Latency calculations %d", s.totalLatency)
+	if s.Latency.Load() < 15000 {
+		t.Fatalf("Incorrect Latency calculations %d", s.Latency.Load())
+	}
+	if latency, ok := s.ModelLatency("gpt-4o"); !ok || latency.Average < 15*time.Second || latency.P99 < 15*time.Second || latency.Samples != 1 {
+		t.Fatalf("Incorrect model latency %+v", latency)
+	}
+	if _, ok := s.ModelLatency("gpt-35-turbo"); ok {
+		t.Fatalf("A model that was never served should have no latency")
+	}
+}
+
+func TestConcurrentFlights(t *testing.T) {
+	s := getServer()
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			s.preFlight()
+			s.postFlight("gpt-4o", start)
+		}()
+	}
+	wg.Wait()
+	if s.ActiveConnections.Load() != 0 {
+		t.Fatalf("Incorrect Active Connections calculations %d", s.ActiveConnections.Load())
 	}
-	if s.Latency <= 2 {
-		t.Fatalf("Incorrect Latency calculations %d", s.Latency)
+	if s.totalRequests != 100 {
+		t.Fatalf("Incorrect Total Requests calculations %d", s.totalRequests)
 	}
 }
 
-func getServer() RouterServer {
+func getServer() *RouterServer {
 	server, _ := NewRouterServer(
 		ServerConfig{
 			Type:            AzureOpenAiServerType,
+			AzureAPIVersion: "2024-06-01",
 			Endpoint:        "https://azure-openai.com",
 			ApiKey:          "azure-openai-key",
 			AvailableModels: []string{"gpt-3.5-turbo", "gpt-4-turbo"},
 		},
 	)
-	return *server
+	return server
+}
+
+func TestIsAvailable(t *testing.T) {
+	s := getServer()
+	if !s.IsAvailable("gpt-4-turbo") {
+		t.Fatal("Server should be available for a model it serves")
+	}
+	if s.IsAvailable("gpt-4o") {
+		t.Fatal("Server should not be available for a model it does not serve")
+	}
+	s.breaker.open()
+	if s.IsAvailable("gpt-4-turbo") || s.CircuitState() != CircuitOpen {
+		t.Fatal("Server should not be available when its circuit is open")
+	}
+	if _, err := s.NewCompletion(context.TODO(), openai.ChatCompletionNewParams{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen but got %v", err)
+	}
 }
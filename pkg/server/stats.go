@@ -0,0 +1,113 @@
+package server
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+const (
+	// defaultStatsHalfLife is the time after which a sample counts half as much in the latency averages.
+	defaultStatsHalfLife = time.Minute
+	// latencySamples is the number of recent samples the latency percentiles are computed from.
+	latencySamples = 256
+	// latencySampleHalfLives is the age, in half-lives, after which a sample no longer counts in the percentiles.
+	latencySampleHalfLives = 5
+)
+
+// LatencyStats are the recent latencies of the requests sent to a server for a model.
+type LatencyStats struct {
+	Average time.Duration // Average is the decaying average latency, recent samples weigh more than old ones.
+	P50     time.Duration
+	P95     time.Duration
+	P99     time.Duration
+	Samples int // Samples is the number of recent samples the percentiles are computed from.
+}
+
+// Throughput is the recent speed at which a server processes the tokens of a model, which unlike the latency
+// does not depend on the length of the prompts and of the completions the server happened to receive.
+type Throughput struct {
+	// TimePerOutputToken is the average time to generate a completion token. For streamed requests it excludes
+	// the time to first token.
+	TimePerOutputToken time.Duration
+	// PromptTokensPerSecond is the average rate at which prompt tokens are processed. For streamed requests it is
+	// measured until the first token, otherwise until the whole completion is received.
+	PromptTokensPerSecond float64
+}
+
+// decayingAverage is an average whose samples lose half of their weight every half-life, so that it follows
+// recent changes instead of the whole history of the server.
+type decayingAverage struct {
+	value     float64
+	weight    float64
+	updatedAt time.Time
+}
+
+func (a *decayingAverage) add(sample float64, now time.Time, halfLife time.Duration) {
+	if !a.updatedAt.IsZero() {
+		a.weight *= math.Exp2(-float64(now.Sub(a.updatedAt)) / float64(halfLife))
+	}
+	a.value = (a.value*a.weight + sample) / (a.weight + 1)
+	a.weight++
+	a.updatedAt = now
+}
+
+// latencySample is a latency observed at a given time.
+type latencySample struct {
+	latency time.Duration
+	at      time.Time
+}
+
+// latencyTracker keeps the decaying average of a latency and its last samples for the percentiles.
+type latencyTracker struct {
+	average decayingAverage
+	samples [latencySamples]latencySample
+	next    int
+}
+
+func (l *latencyTracker) add(latency time.Duration, now time.Time, halfLife time.Duration) {
+	l.average.add(float64(latency), now, halfLife)
+	l.samples[l.next] = latencySample{latency: latency, at: now}
+	l.next = (l.next + 1) % len(l.samples)
+}
+
+// known reports whether a latency was ever recorded.
+func (l *latencyTracker) known() bool {
+	return !l.average.updatedAt.IsZero()
+}
+
+// stats returns the average and the percentiles of the samples recorded within latencySampleHalfLives of now.
+func (l *latencyTracker) stats(now time.Time, halfLife time.Duration) LatencyStats {
+	oldest := now.Add(-latencySampleHalfLives * halfLife)
+	latencies := make([]time.Duration, 0, len(l.samples))
+	for _, sample := range l.samples {
+		if !sample.at.IsZero() && !sample.at.Before(oldest) {
+			latencies = append(latencies, sample.latency)
+		}
+	}
+	slices.Sort(latencies)
+	return LatencyStats{
+		Average: time.Duration(l.average.value),
+		P50:     percentile(latencies, 50),
+		P95:     percentile(latencies, 95),
+		P99:     percentile(latencies, 99),
+		Samples: len(latencies),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of the sorted latencies, zero if there are none.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// modelStats are the stats of the requests sent to a server for a single model.
+type modelStats struct {
+	latency               latencyTracker
+	timeToFirstToken      latencyTracker
+	timePerOutputToken    decayingAverage
+	promptTokensPerSecond decayingAverage
+}
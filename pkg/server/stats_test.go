@@ -0,0 +1,80 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecayingAverage(t *testing.T) {
+	a := decayingAverage{}
+	now := time.Unix(1000, 0)
+	a.add(100, now, time.Minute)
+	if a.value != 100 {
+		t.Fatalf("The first sample should be the average, got %f", a.value)
+	}
+	a.add(200, now, time.Minute)
+	if a.value != 150 {
+		t.Fatalf("Samples at the same time should weigh the same, got %f", a.value)
+	}
+	a.add(1000, now.Add(time.Hour), time.Minute)
+	if a.value < 999 {
+		t.Fatalf("Old samples should have decayed, got %f", a.value)
+	}
+
+	b := decayingAverage{}
+	b.add(100, now, time.Minute)
+	b.add(400, now.Add(time.Minute), time.Minute)
+	// After one half-life the first sample weighs 0.5 against 1 for the new one.
+	if b.value != 300 {
+		t.Fatalf("Incorrect decayed average %f", b.value)
+	}
+}
+
+func TestLatencyTrackerPercentiles(t *testing.T) {
+	l := latencyTracker{}
+	now := time.Unix(1000, 0)
+	for i := 1; i <= 100; i++ {
+		l.add(time.Duration(i)*time.Millisecond, now, time.Minute)
+	}
+	stats := l.stats(now, time.Minute)
+	if stats.Samples != 100 {
+		t.Fatalf("Incorrect number of samples %d", stats.Samples)
+	}
+	if stats.P50 != 50*time.Millisecond || stats.P95 != 95*time.Millisecond || stats.P99 != 99*time.Millisecond {
+		t.Fatalf("Incorrect percentiles %+v", stats)
+	}
+	if stats.Average != 50500*time.Microsecond {
+		t.Fatalf("Incorrect average %s", stats.Average)
+	}
+
+	// Samples older than latencySampleHalfLives half-lives no longer count in the percentiles.
+	l.add(time.Second, now.Add(time.Hour), time.Minute)
+	stats = l.stats(now.Add(time.Hour), time.Minute)
+	if stats.Samples != 1 || stats.P50 != time.Second {
+		t.Fatalf("Old samples should have been dropped, got %+v", stats)
+	}
+	if stats.Average < 999*time.Millisecond {
+		t.Fatalf("Old samples should have decayed, got %s", stats.Average)
+	}
+}
+
+func TestLatencyTrackerKeepsLastSamples(t *testing.T) {
+	l := latencyTracker{}
+	now := time.Unix(1000, 0)
+	for i := 0; i < latencySamples*2; i++ {
+		l.add(time.Duration(i)*time.Millisecond, now, time.Minute)
+	}
+	stats := l.stats(now, time.Minute)
+	if stats.Samples != latencySamples {
+		t.Fatalf("Incorrect number of samples %d", stats.Samples)
+	}
+	if stats.P50 < latencySamples*time.Millisecond {
+		t.Fatalf("The percentiles should only use the last samples, got %+v", stats)
+	}
+}
+
+func TestPercentileWithoutSamples(t *testing.T) {
+	if p := percentile(nil, 99); p != 0 {
+		t.Fatalf("Incorrect percentile without samples %s", p)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/packages/ssestream"
+)
+
+// ErrStreamFailed is the error of a stream that sent an error event or ended before its last event. It is counted
+// as a failure of the server by the circuit breaker.
+var ErrStreamFailed = errors.New("stream failed")
+
+// tokenUsage is the number of tokens of a request, as reported in the usage of its response.
+type tokenUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+func (u tokenUsage) total() int64 {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// streamTokens returns the tokens a stream used, to settle the estimated tokens it reserved. It is the usage
+// reported by the stream when it is known, none when the stream failed before its first event, and the estimate
+// otherwise.
+func streamTokens(estimated int64, err error, started bool, result streamResult) int64 {
+	if total := result.usage.total(); total > 0 {
+		return total
+	}
+	if err != nil && !started {
+		return 0
+	}
+	return estimated
+}
+
+// streamResult is what a stream reported about itself until it ended.
+type streamResult struct {
+	usage      tokenUsage
+	responseID string // responseID is the id of the response of the streams of the Responses API.
+}
+
+// trackedDecoder wraps the decoder of a streamed response to know when its first event arrives and when
+// the stream ends, either because it was read until the end, failed or was closed.
+// It also keeps the usage of the stream, which chat completions send in the last chunk when the request sets
+// stream_options.include_usage and responses send in their response.completed event.
+type trackedDecoder struct {
+	ssestream.Decoder
+	start        time.Time
+	firstEvent   bool
+	onFirstEvent func(timeToFirstToken time.Duration)
+	result       streamResult
+	done         bool  // done is set by the last event of the stream, [DONE] or the end of a response.
+	err          error // err is the error event of the stream, which stops the stream without ending the decoder.
+	finishOnce   sync.Once
+	onFinish     func(err error, result streamResult)
+}
+
+func newTrackedDecoder(decoder ssestream.Decoder, start time.Time, onFirstEvent func(time.Duration), onFinish func(error, streamResult)) *trackedDecoder {
+	return &trackedDecoder{
+		Decoder:      decoder,
+		start:        start,
+		onFirstEvent: onFirstEvent,
+		onFinish:     onFinish,
+	}
+}
+
+func (d *trackedDecoder) Next() bool {
+	if !d.Decoder.Next() {
+		err := cmp.Or(d.Decoder.Err(), d.err)
+		if err == nil && !d.done {
+			err = fmt.Errorf("%w: ended before its last event", ErrStreamFailed)
+		}
+		d.finish(err)
+		return false
+	}
+	if !d.firstEvent {
+		d.firstEvent = true
+		d.onFirstEvent(time.Since(d.start))
+	}
+	d.observe(d.Decoder.Event().Data)
+	return true
+}
+
+func (d *trackedDecoder) observe(data []byte) {
+	if bytes.HasPrefix(data, []byte("[DONE]")) {
+		d.done = true
+		return
+	}
+	if !bytes.Contains(data, []byte(`"usage"`)) && !bytes.Contains(data, []byte(`"response"`)) && !bytes.Contains(data, []byte(`"error"`)) {
+		return
+	}
+	var chunk struct {
+		Type     string          `json:"type"`
+		Error    json.RawMessage `json:"error"`
+		Usage    *tokenUsage     `json:"usage"`
+		Response *struct {
+			ID    string         `json:"id"`
+			Usage *ResponseUsage `json:"usage"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return
+	}
+	// The stream stops at the events that have an error, like the openai client does.
+	if len(chunk.Error) > 0 && !bytes.Equal(chunk.Error, []byte("null")) {
+		d.err = fmt.Errorf("%w: %s", ErrStreamFailed, chunk.Error)
+	}
+	switch chunk.Type {
+	case "response.completed", "response.incomplete":
+		d.done = true
+	case "response.failed":
+		d.err = fmt.Errorf("%w: response failed", ErrStreamFailed)
+	}
+	if chunk.Usage != nil {
+		d.result.usage = *chunk.Usage
+	}
+	if chunk.Response != nil {
+		d.result.responseID = chunk.Response.ID
+		if chunk.Response.Usage != nil {
+			d.result.usage = chunk.Response.Usage.tokenUsage()
+		}
+	}
+}
+
+// Close closes the stream. A stream closed after an error event, which the openai client does not read past,
+// finishes with the error of the event. A stream closed early by the caller is not a failure.
+func (d *trackedDecoder) Close() error {
+	err := d.Decoder.Close()
+	d.finish(d.err)
+	return err
+}
+
+func (d *trackedDecoder) finish(err error) {
+	d.finishOnce.Do(func() {
+		d.onFinish(err, d.result)
+	})
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+func TestStreamingAccounting(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(80 * time.Millisecond)
+		w.Write([]byte("data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"!\"}}]}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer ts.Close()
+	s := getServerForEndpoint(t, ts.URL)
+
+	stream := s.NewStreamingCompletion(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT4o)})
+	if stream.Err() != nil {
+		t.Fatalf("Error was not expected %v", stream.Err())
+	}
+	if s.ActiveConnections.Load() != 1 {
+		t.Fatalf("An open stream should count as an active connection, got %d", s.ActiveConnections.Load())
+	}
+	chunks := 0
+	for stream.Next() {
+		chunks++
+	}
+	if stream.Err() != nil || chunks != 2 {
+		t.Fatalf("Incorrect stream, chunks %d, error %v", chunks, stream.Err())
+	}
+	if s.ActiveConnections.Load() != 0 {
+		t.Fatalf("A stream read until the end should not count as an active connection, got %d", s.ActiveConnections.Load())
+	}
+	if s.TimeToFirstToken.Load() < 20 || s.TimeToFirstToken.Load() >= 100 {
+		t.Fatalf("Incorrect time to first token %d", s.TimeToFirstToken.Load())
+	}
+	if ttft, ok := s.ModelTimeToFirstToken("gpt-4o"); !ok || ttft < 20*time.Millisecond || ttft >= 100*time.Millisecond {
+		t.Fatalf("Incorrect time to first token for the model %v", ttft)
+	}
+	if _, ok := s.ModelTimeToFirstToken("gpt-35-turbo"); ok {
+		t.Fatal("A model that was never streamed should not have a time to first token")
+	}
+	if s.Latency.Load() < 100 {
+		t.Fatalf("Latency should be the whole duration of the stream, got %d", s.Latency.Load())
+	}
+	stream.Close()
+	if s.ActiveConnections.Load() != 0 || s.totalRequests != 1 {
+		t.Fatal("Closing a finished stream should not be accounted twice")
+	}
+}
+
+func TestStreamingThroughput(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}],\"usage\":null}\n\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"choices\":[],\"usage\":{\"prompt_tokens\":100,\"completion_tokens\":10,\"total_tokens\":110}}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer ts.Close()
+	s := getServerForEndpoint(t, ts.URL)
+
+	stream := s.NewStreamingCompletion(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT4o)})
+	for stream.Next() {
+	}
+	if stream.Err() != nil {
+		t.Fatalf("Error was not expected %v", stream.Err())
+	}
+	throughput, ok := s.ModelThroughput("gpt-4o")
+	// The time to first token is not part of the generation time. The client sees the first event a little after
+	// it is flushed, so the generation can take slightly less than the 100ms the server sleeps.
+	if !ok || throughput.TimePerOutputToken < 5*time.Millisecond || throughput.TimePerOutputToken >= 30*time.Millisecond {
+		t.Fatalf("Incorrect time per output token %+v", throughput)
+	}
+	// The prompt is processed until the first token.
+	if throughput.PromptTokensPerSecond <= 250 || throughput.PromptTokensPerSecond > 4000 {
+		t.Fatalf("Incorrect prompt tokens per second %+v", throughput)
+	}
+}
+
+func TestStreamingQuota(t *testing.T) {
+	failing := atomic.Bool{}
+	failing.Store(true)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if failing.Load() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"test error","type":"test"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"choices\":[],\"usage\":{\"prompt_tokens\":100,\"completion_tokens\":10,\"total_tokens\":110}}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer ts.Close()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            AzureOpenAiServerType,
+		Endpoint:        ts.URL,
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		AvailableModels: []string{"gpt-4o"},
+		ModelLimits:     map[string]ModelLimits{"gpt-4o": {TokensPerMinute: 1000}},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	body := openai.ChatCompletionNewParams{
+		Model:     openai.F(openai.ChatModelGPT4o),
+		Messages:  openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")}),
+		MaxTokens: openai.F(int64(200)),
+	}
+	for range 3 {
+		stream := s.NewStreamingCompletion(context.TODO(), body)
+		if stream.Err() == nil {
+			t.Fatal("Error was expected")
+		}
+	}
+	if s.Headroom("gpt-4o") != 1 {
+		t.Fatalf("The streams that failed should not use tokens, headroom %f", s.Headroom("gpt-4o"))
+	}
+
+	failing.Store(false)
+	stream := s.NewStreamingCompletion(context.TODO(), body)
+	for stream.Next() {
+	}
+	if stream.Err() != nil {
+		t.Fatalf("Error was not expected %v", stream.Err())
+	}
+	if headroom := s.Headroom("gpt-4o"); headroom != 0.89 {
+		t.Fatalf("The stream should use the tokens of its usage, headroom %f", headroom)
+	}
+}
+
+func TestStreamingFailures(t *testing.T) {
+	tests := map[string]string{
+		"error event": "data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"choices\":[]}\n\n" +
+			"data: {\"error\":{\"message\":\"server error\",\"type\":\"server_error\"}}\n\n",
+		"truncated": "data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"choices\":[]}\n\n",
+	}
+	for name, events := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(events))
+		}))
+		s, err := NewRouterServer(ServerConfig{
+			Type:            AzureOpenAiServerType,
+			Endpoint:        ts.URL,
+			AzureAPIVersion: "2024-06-01",
+			ApiKey:          "azure-openai-key",
+			AvailableModels: []string{"gpt-4o"},
+			CircuitBreaker:  CircuitBreakerConfig{ConsecutiveFailures: 1},
+		})
+		if err != nil {
+			t.Fatalf("%s: error was not expected %v", name, err)
+		}
+		stream := s.NewStreamingCompletion(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT4o)})
+		for stream.Next() {
+		}
+		stream.Close()
+		if s.CircuitState() != CircuitOpen {
+			t.Fatalf("%s: the failed stream should be recorded by the circuit breaker, got %s", name, s.CircuitState())
+		}
+		ts.Close()
+	}
+}
+
+func TestStreamingClosedEarly(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"choices\":[]}\n\n"))
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer ts.Close()
+	s := getServerForEndpoint(t, ts.URL)
+
+	stream := s.NewStreamingCompletion(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT4o)})
+	if !stream.Next() {
+		t.Fatalf("A chunk was expected, error %v", stream.Err())
+	}
+	if s.ActiveConnections.Load() != 1 {
+		t.Fatalf("An open stream should count as an active connection, got %d", s.ActiveConnections.Load())
+	}
+	stream.Close()
+	if s.ActiveConnections.Load() != 0 {
+		t.Fatalf("A closed stream should not count as an active connection, got %d", s.ActiveConnections.Load())
+	}
+}
+
+func TestStreamingError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	s := getServerForEndpoint(t, ts.URL)
+
+	stream := s.NewStreamingCompletion(context.TODO(), openai.ChatCompletionNewParams{Model: openai.F(openai.ChatModelGPT4o)})
+	if stream.Err() == nil {
+		t.Fatal("Error was expected")
+	}
+	if s.ActiveConnections.Load() != 0 || s.TimeToFirstToken.Load() != 0 {
+		t.Fatal("A failed stream should not count as an active connection")
+	}
+}
+
+func getServerForEndpoint(t *testing.T, endpoint string) *RouterServer {
+	t.Helper()
+	s, err := NewRouterServer(ServerConfig{
+		Type:            AzureOpenAiServerType,
+		Endpoint:        endpoint,
+		AzureAPIVersion: "2024-06-01",
+		ApiKey:          "azure-openai-key",
+		AvailableModels: []string{"gpt-4o"},
+	})
+	if err != nil {
+		t.Fatalf("Error was not expected %v", err)
+	}
+	return s
+}